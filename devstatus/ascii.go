@@ -0,0 +1,173 @@
+package devstatus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultASCIIPort is the TCP port of HomeSeer's ASCII interface.
+	DefaultASCIIPort = 11000
+	// DefaultReconnectDelay is the initial delay before reconnecting to the
+	// ASCII interface when WatchOptions.ReconnectDelay is zero.
+	DefaultReconnectDelay = time.Second
+	// maxReconnectDelay caps the exponential reconnect backoff.
+	maxReconnectDelay = time.Minute
+)
+
+// DeviceChange is a device value change pushed by HomeSeer's ASCII interface.
+type DeviceChange struct {
+	Reference int
+	Value     float64
+	OldValue  float64
+	// Received is when the notification arrived.
+	Received time.Time
+}
+
+// WatchOptions configures a Watcher.
+type WatchOptions struct {
+	// Address is the host:port of the ASCII interface.  If the port is
+	// omitted, DefaultASCIIPort is used.
+	Address string
+	// Username is the identity to log in as.  Empty to skip logging in.
+	Username string
+	// Password is the credential to log in with.
+	Password string
+	// ReconnectDelay is the delay before the first reconnect after the
+	// connection fails.  It doubles on each consecutive failure, up to a
+	// minute.  Zero means DefaultReconnectDelay.
+	ReconnectDelay time.Duration
+	// OnError, if set, is informed of each connection failure.
+	OnError func(error)
+}
+
+// Watcher streams device changes from HomeSeer's ASCII interface,
+// reconnecting whenever the connection is lost.
+type Watcher struct {
+	opts WatchOptions
+}
+
+// NewWatcher creates a Watcher for the given options.
+func NewWatcher(opts WatchOptions) (*Watcher, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("Address is required")
+	}
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		opts.Address = net.JoinHostPort(opts.Address, strconv.Itoa(DefaultASCIIPort))
+	}
+	if opts.Username != "" && opts.Password == "" {
+		return nil, fmt.Errorf("when Username is provided you must also provide a password")
+	}
+	if opts.ReconnectDelay == 0 {
+		opts.ReconnectDelay = DefaultReconnectDelay
+	}
+	return &Watcher{opts: opts}, nil
+}
+
+// Run calls onChange for every device change until ctx is done.
+// onChange is called from a single goroutine.
+func (w *Watcher) Run(ctx context.Context, onChange func(DeviceChange)) error {
+	delay := w.opts.ReconnectDelay
+	for {
+		loggedIn, err := w.session(ctx, onChange)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if loggedIn {
+			delay = w.opts.ReconnectDelay
+		}
+		glog.Errorf("devstatus: ASCII interface %s: %v; reconnecting in %s", w.opts.Address, err, delay)
+		if w.opts.OnError != nil {
+			w.opts.OnError(err)
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// session handles one connection.  It reports whether login succeeded, and
+// always returns the error that ended the connection.
+func (w *Watcher) session(ctx context.Context, onChange func(DeviceChange)) (bool, error) {
+	d := net.Dialer{KeepAlive: 30 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", w.opts.Address)
+	if err != nil {
+		return false, err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		_ = conn.Close()
+	}()
+	r := bufio.NewScanner(conn)
+	if w.opts.Username != "" {
+		if _, err := fmt.Fprintf(conn, "au,%s,%s\r\n", w.opts.Username, w.opts.Password); err != nil {
+			return false, err
+		}
+		if !r.Scan() {
+			return false, fmt.Errorf("login: %v", scanErr(r))
+		}
+		if reply := strings.TrimSpace(r.Text()); !strings.EqualFold(reply, "ok") {
+			return false, classified{kind: ErrUnauthorized, err: fmt.Errorf("login: homeseer replied %q", reply)}
+		}
+	}
+	glog.Infof("devstatus: watching ASCII interface %s", w.opts.Address)
+	for r.Scan() {
+		dc, ok, err := parseASCIILine(r.Text())
+		if err != nil {
+			glog.Warningf("devstatus: ASCII interface %s: %v", w.opts.Address, err)
+			continue
+		}
+		if ok {
+			dc.Received = time.Now()
+			onChange(dc)
+		}
+	}
+	return true, scanErr(r)
+}
+
+func scanErr(r *bufio.Scanner) error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("connection closed")
+}
+
+// parseASCIILine parses a device change notification of the form
+// "DC,ref,newvalue,oldvalue".  ok is false for other notifications.
+func parseASCIILine(line string) (DeviceChange, bool, error) {
+	fields := strings.Split(strings.TrimSpace(line), ",")
+	if !strings.EqualFold(fields[0], "DC") {
+		return DeviceChange{}, false, nil
+	}
+	if len(fields) != 4 {
+		return DeviceChange{}, false, fmt.Errorf("malformed device change: %q", line)
+	}
+	ref, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return DeviceChange{}, false, fmt.Errorf("malformed device change: %q: %v", line, err)
+	}
+	value, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return DeviceChange{}, false, fmt.Errorf("malformed device change: %q: %v", line, err)
+	}
+	old, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return DeviceChange{}, false, fmt.Errorf("malformed device change: %q: %v", line, err)
+	}
+	return DeviceChange{Reference: ref, Value: value, OldValue: old}, true, nil
+}
@@ -0,0 +1,125 @@
+package devstatus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeASCIIServer accepts connections, expects a login, and writes the
+// next batch of lines to each successive connection before closing it.
+func fakeASCIIServer(t *testing.T, batches ...[]string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	go func() {
+		for _, batch := range batches {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			login, err := r.ReadString('\n')
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			if login != "au,user,secret\r\n" {
+				fmt.Fprintf(conn, "error, bad login\r\n")
+				_ = conn.Close()
+				continue
+			}
+			fmt.Fprintf(conn, "ok\r\n")
+			for _, line := range batch {
+				fmt.Fprintf(conn, "%s\r\n", line)
+			}
+			_ = conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestWatcherReconnects(t *testing.T) {
+	noSleep(t)
+	addr := fakeASCIIServer(t,
+		[]string{"DC,12,255,0", "SC,12,Open", "DC,bogus", "DC,13,21.5,21"},
+		[]string{"DC,12,0,255"},
+	)
+	w, err := NewWatcher(WatchOptions{Address: addr, Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewWatcher(): %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var got []DeviceChange
+	err = w.Run(ctx, func(dc DeviceChange) {
+		got = append(got, dc)
+		if len(got) == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("Run(): got %v, want %v", err, context.Canceled)
+	}
+	want := []DeviceChange{
+		{Reference: 12, Value: 255, OldValue: 0},
+		{Reference: 13, Value: 21.5, OldValue: 21},
+		{Reference: 12, Value: 0, OldValue: 255},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Run(): got %d changes %+v, want %+v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i].Received.IsZero() {
+			t.Errorf("change %d: got zero Received time", i)
+		}
+		got[i].Received = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("change %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWatcherBadLogin(t *testing.T) {
+	noSleep(t)
+	addr := fakeASCIIServer(t, nil)
+	var gotErr error
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	w, err := NewWatcher(WatchOptions{
+		Address:  addr,
+		Username: "user",
+		Password: "wrong",
+		OnError: func(err error) {
+			gotErr = err
+			cancel()
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWatcher(): %v", err)
+	}
+	_ = w.Run(ctx, func(DeviceChange) {
+		t.Errorf("onChange called after a failed login")
+	})
+	want := `login: homeseer replied "error, bad login"`
+	if gotErr == nil || gotErr.Error() != want {
+		t.Errorf("OnError: got %v, want %s", gotErr, want)
+	}
+}
+
+func TestNewWatcherDefaultPort(t *testing.T) {
+	w, err := NewWatcher(WatchOptions{Address: "hs4.local"})
+	if err != nil {
+		t.Fatalf("NewWatcher(): %v", err)
+	}
+	if want := "hs4.local:11000"; w.opts.Address != want {
+		t.Errorf("Address: got %q, want %q", w.opts.Address, want)
+	}
+}
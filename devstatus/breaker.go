@@ -0,0 +1,142 @@
+package devstatus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultBreakerCooldown is how long the circuit breaker first stays open
+	// when ClientOptions.BreakerCooldown is zero.
+	DefaultBreakerCooldown = 5 * time.Second
+	// maxBreakerCooldown caps the breaker's exponential backoff.
+	maxBreakerCooldown = 5 * time.Minute
+)
+
+// clock is swapped in tests.
+var clock = time.Now
+
+// BreakerState is the state of a Client's circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests without sending them, to give HomeSeer a rest.
+	BreakerOpen
+	// BreakerHalfOpen lets a single request through to see whether HomeSeer
+	// has recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// breaker opens after threshold consecutive failures.  Once its cooldown
+// passes it half-opens, letting one request probe HomeSeer: success closes
+// it, and failure reopens it with the cooldown doubled.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	// wait is the current cooldown, and until is when it ends.
+	wait  time.Duration
+	until time.Time
+}
+
+// allow reports whether a request may be sent.  A nil breaker allows everything.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if left := b.until.Sub(clock()); left > 0 {
+			return classified{kind: ErrCircuitOpen, err: fmt.Errorf("%w for another %s", ErrCircuitOpen, left.Round(time.Millisecond))}
+		}
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		// The probe is still in flight.
+		return classified{kind: ErrCircuitOpen, err: fmt.Errorf("%w while a probe is in flight", ErrCircuitOpen)}
+	}
+	return nil
+}
+
+// record notes the outcome of a request that allow let through.
+func (b *breaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.state != BreakerClosed {
+			glog.Infof("devstatus: homeseer recovered; closing circuit breaker")
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		b.wait = 0
+		return
+	}
+	b.failures++
+	switch {
+	case b.state == BreakerHalfOpen:
+		if b.wait *= 2; b.wait > maxBreakerCooldown {
+			b.wait = maxBreakerCooldown
+		}
+	case b.failures >= b.threshold:
+		b.wait = b.cooldown
+	default:
+		return
+	}
+	b.state = BreakerOpen
+	b.until = clock().Add(b.wait)
+	glog.Warningf("devstatus: %d consecutive failures; opening circuit breaker for %s", b.failures, b.wait)
+}
+
+// abandon notes that a request allow let through ended without telling
+// whether HomeSeer is healthy, such as when its caller gave up or HomeSeer
+// rejected the credentials.  The count of consecutive failures is kept.
+func (b *breaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		// Let the next request probe instead.
+		b.state = BreakerOpen
+		b.until = clock()
+	}
+}
+
+func (b *breaker) current() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !clock().Before(b.until) {
+		// The next request will probe.
+		return BreakerHalfOpen
+	}
+	return b.state
+}
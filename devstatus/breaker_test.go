@@ -0,0 +1,166 @@
+package devstatus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fakeClock(t *testing.T) *time.Time {
+	save := clock
+	t.Cleanup(func() {
+		clock = save
+	})
+	now := time.Unix(1600000000, 0)
+	clock = func() time.Time {
+		return now
+	}
+	return &now
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	noSleep(t)
+	now := fakeClock(t)
+	var calls int32
+	var healthy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"Name":"HomeSeer Devices","Devices":[]}`))
+	}))
+	defer srv.Close()
+	c, err := NewClient(ClientOptions{
+		BaseURL:          srv.URL,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	get := func(wantCalls int32, wantState BreakerState) error {
+		t.Helper()
+		atomic.StoreInt32(&calls, 0)
+		_, err := c.Get(context.Background())
+		if calls != wantCalls {
+			t.Errorf("calls: got %d, want %d", calls, wantCalls)
+		}
+		if got := c.BreakerState(); got != wantState {
+			t.Errorf("BreakerState(): got %v, want %v", got, wantState)
+		}
+		return err
+	}
+
+	get(1, BreakerClosed)
+	get(1, BreakerOpen)
+	if err := get(0, BreakerOpen); !errors.Is(err, ErrCircuitOpen) || Reason(err) != "circuit_open" {
+		t.Errorf("Get() while open: got %v, want ErrCircuitOpen", err)
+	}
+
+	// The probe fails, so the breaker reopens for twice as long.
+	*now = now.Add(time.Second)
+	if got := c.BreakerState(); got != BreakerHalfOpen {
+		t.Errorf("BreakerState() after cooldown: got %v, want half_open", got)
+	}
+	get(1, BreakerOpen)
+	*now = now.Add(time.Second)
+	get(0, BreakerOpen)
+
+	*now = now.Add(time.Second)
+	atomic.StoreInt32(&healthy, 1)
+	if err := get(1, BreakerClosed); err != nil {
+		t.Errorf("Get() after recovery: got %v, want nil error", err)
+	}
+}
+
+func TestClientCircuitBreakerIgnoresUnhealthyAnswers(t *testing.T) {
+	noSleep(t)
+	now := fakeClock(t)
+	var reply atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch reply.Load().(string) {
+		case "down":
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		case "unauthorized":
+			rw.WriteHeader(http.StatusUnauthorized)
+		case "html":
+			_, _ = rw.Write([]byte("<html><body>Enable Control with JSON</body></html>"))
+		default:
+			_, _ = rw.Write([]byte(`{"Name":"HomeSeer Devices","Devices":[]}`))
+		}
+	}))
+	defer srv.Close()
+	c, err := NewClient(ClientOptions{
+		BaseURL:          srv.URL,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	get := func(r string, wantState BreakerState) {
+		t.Helper()
+		reply.Store(r)
+		_, _ = c.Get(context.Background())
+		if got := c.BreakerState(); got != wantState {
+			t.Errorf("BreakerState() after %s: got %v, want %v", r, got, wantState)
+		}
+	}
+
+	// Rejected credentials neither count as a failure nor reset the count.
+	get("down", BreakerClosed)
+	get("unauthorized", BreakerClosed)
+	get("down", BreakerOpen)
+
+	// Nor does a probe answered with HTML close the breaker; the next
+	// request probes again.
+	*now = now.Add(time.Second)
+	get("html", BreakerHalfOpen)
+	get("ok", BreakerClosed)
+}
+
+func TestBreakerHalfOpenAllowsOneProbe(t *testing.T) {
+	now := fakeClock(t)
+	b := &breaker{threshold: 1, cooldown: time.Second}
+	b.record(true)
+	*now = now.Add(time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() after cooldown: got %v, want nil", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("allow() during probe: got %v, want ErrCircuitOpen", err)
+	}
+	// A probe abandoned by its caller lets the next request probe.
+	b.abandon()
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after abandoned probe: got %v, want nil", err)
+	}
+}
+
+func TestBreakerCooldownIsCapped(t *testing.T) {
+	now := fakeClock(t)
+	b := &breaker{threshold: 1, cooldown: time.Minute}
+	b.record(true)
+	for i := 0; i < 10; i++ {
+		*now = b.until
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow(): %v", err)
+		}
+		b.record(true)
+	}
+	if b.wait != maxBreakerCooldown {
+		t.Errorf("wait: got %s, want %s", b.wait, maxBreakerCooldown)
+	}
+}
+
+func TestNewClientRejectsNegativeBreakerThreshold(t *testing.T) {
+	if _, err := NewClient(ClientOptions{BaseURL: "http://hs", BreakerThreshold: -1}); err == nil {
+		t.Error("NewClient(): got nil error, want error")
+	}
+}
@@ -0,0 +1,268 @@
+package devstatus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultTimeout bounds a single request to HomeSeer when ClientOptions.Timeout is zero.
+	DefaultTimeout = 10 * time.Second
+	// DefaultBackoff is the initial delay between retries when ClientOptions.Backoff is zero.
+	DefaultBackoff = 250 * time.Millisecond
+)
+
+var (
+	httpgetwithbasicauth = getWithBasicAuth
+	sleep                = sleepContext
+)
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// BaseURL locates the homeseer server, including scheme and any path
+	// prefix.  Example: "https://proxy.example.com/homeseer"
+	BaseURL string
+	// TLS configures connections when BaseURL uses https.
+	TLS TLSOptions
+	// Username is the identity to present as authentication.  Empty for none.
+	Username string
+	// Password is the credential to present as authentication.
+	Password string
+	// Timeout bounds each individual HTTP request, including retries
+	// individually.  Zero means DefaultTimeout.
+	Timeout time.Duration
+	// Retries is the number of additional attempts made after a transient
+	// failure.  Zero disables retries.
+	Retries int
+	// Backoff is the delay before the first retry.  It doubles on each
+	// subsequent retry and is jittered.  Zero means DefaultBackoff.
+	Backoff time.Duration
+	// BreakerThreshold, if non zero, is the number of consecutive failed
+	// requests, after retries, that open the circuit breaker.  While it is
+	// open, requests fail with ErrCircuitOpen without reaching HomeSeer.
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker first stays open before
+	// letting one request through to probe HomeSeer.  It doubles each time
+	// the probe fails, up to five minutes.  Zero means DefaultBreakerCooldown.
+	BreakerCooldown time.Duration
+}
+
+// Client fetches device data from a single HomeSeer instance.
+// A Client holds one HTTP transport so connections are reused across calls.
+// It is safe for concurrent use.
+type Client struct {
+	opts   ClientOptions
+	base   *url.URL
+	client *http.Client
+	// breaker is nil unless opts.BreakerThreshold is set.
+	breaker *breaker
+}
+
+// NewClient creates a Client for the given options.
+func NewClient(opts ClientOptions) (*Client, error) {
+	base, err := parseBaseURL(opts.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Username != "" && opts.Password == "" {
+		return nil, fmt.Errorf("when Username is provided you must also provide a password")
+	}
+	if opts.Retries < 0 {
+		return nil, fmt.Errorf("Retries must not be negative, got %d", opts.Retries)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.BreakerThreshold < 0 {
+		return nil, fmt.Errorf("BreakerThreshold must not be negative, got %d", opts.BreakerThreshold)
+	}
+	if opts.BreakerCooldown == 0 {
+		opts.BreakerCooldown = DefaultBreakerCooldown
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	if transport.TLSClientConfig, err = tlsConfig(opts.TLS); err != nil {
+		return nil, err
+	}
+	rval := &Client{
+		opts:   opts,
+		base:   base,
+		client: &http.Client{Transport: transport},
+	}
+	if opts.BreakerThreshold > 0 {
+		rval.breaker = &breaker{threshold: opts.BreakerThreshold, cooldown: opts.BreakerCooldown}
+	}
+	return rval, nil
+}
+
+// BreakerState returns the state of the circuit breaker.  It is always
+// BreakerClosed when ClientOptions.BreakerThreshold is zero.
+func (c *Client) BreakerState() BreakerState {
+	return c.breaker.current()
+}
+
+func parseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("BaseURL %q: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("BaseURL %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("BaseURL %q: missing host", raw)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u, nil
+}
+
+// jsonURL returns the URL of the JSON interface for the given request.
+func (c *Client) jsonURL(request string) string {
+	u := *c.base
+	u.Path += "/JSON"
+	u.RawQuery = "request=" + url.QueryEscape(request)
+	return u.String()
+}
+
+// Get retrieves all devices from HomeSeer.
+func (c *Client) Get(ctx context.Context) (*StatusReport, error) {
+	var rval *StatusReport
+	err := c.fetch(ctx, c.jsonURL("getstatus"), func(payload []byte) (err error) {
+		rval, err = parseStatus(payload)
+		return err
+	})
+	return rval, err
+}
+
+// fetch retrieves url through the circuit breaker and decodes it.  Only
+// successes, and failures suggesting HomeSeer is down or overloaded, are
+// recorded by the breaker.  Others, such as rejected credentials or JSON
+// turned off, say nothing about HomeSeer's health and are not.
+func (c *Client) fetch(ctx context.Context, url string, decode func([]byte) error) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	body, err := c.fetchWithRetries(ctx, url)
+	if err == nil {
+		err = decode(body)
+	}
+	switch {
+	case err == nil:
+		c.breaker.record(false)
+	case ctx.Err() == nil && (transient(err) || errors.Is(err, ErrUnreachable)):
+		c.breaker.record(true)
+	default:
+		c.breaker.abandon()
+	}
+	return err
+}
+
+// fetchWithRetries retrieves url, retrying transient failures with jittered exponential backoff.
+func (c *Client) fetchWithRetries(ctx context.Context, url string) ([]byte, error) {
+	delay := c.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		body, err := c.fetchOnce(ctx, url)
+		if err == nil || attempt >= c.opts.Retries || !transient(err) || ctx.Err() != nil {
+			return body, err
+		}
+		glog.V(1).Infof("devstatus: attempt %d of %q failed, retrying: %v", attempt+1, url, err)
+		if err := sleep(ctx, jitter(delay)); err != nil {
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) fetchOnce(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	return httpgetwithbasicauth(ctx, c.client, url, c.opts.Username, c.opts.Password)
+}
+
+// transient reports whether err is worth retrying: a timeout, a refused
+// or reset connection, a truncated response, or a 5xx or 429 answer.
+// Others, such as certificate errors, would only fail again.
+func transient(err error) bool {
+	var se *StatusCodeError
+	if errors.As(err, &se) {
+		return se.Code >= 500 || se.Code == http.StatusTooManyRequests
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// jitter returns a random duration in [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func getWithBasicAuth(ctx context.Context, client *http.Client, url string, username string, password string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if username != "" {
+		req.Header.Add("Authorization", basicAuth(username, password))
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		// Only network failures mean HomeSeer is unreachable; TLS and
+		// configuration errors are returned as they are.
+		var oe *net.OpError
+		if errors.As(err, &oe) || transient(err) {
+			return nil, classified{kind: ErrUnreachable, err: err}
+		}
+		return nil, err
+	}
+	defer func() {
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		if err := r.Body.Close(); err != nil {
+			glog.Errorf("r.Body.Close(): %v", err)
+		}
+	}()
+	if r.StatusCode != 200 {
+		return nil, &StatusCodeError{URL: url, Code: r.StatusCode}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, classified{kind: ErrUnreachable, err: err}
+	}
+	return body, nil
+}
@@ -0,0 +1,142 @@
+package devstatus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func noSleep(t *testing.T) {
+	save := sleep
+	t.Cleanup(func() {
+		sleep = save
+	})
+	sleep = func(ctx context.Context, d time.Duration) error {
+		return ctx.Err()
+	}
+}
+
+func TestClientRetriesTransientFailures(t *testing.T) {
+	noSleep(t)
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if got, want := req.URL.Query().Get("request"), "getstatus"; got != want {
+			t.Errorf("request: got %q, want %q", got, want)
+		}
+		_, _ = rw.Write([]byte(`{"Name":"HomeSeer Devices","Devices":[]}`))
+	}))
+	defer srv.Close()
+	c, err := NewClient(ClientOptions{
+		BaseURL: srv.URL,
+		Retries: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	got, err := c.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get(): got %v, want nil error", err)
+	}
+	if got.Name != "HomeSeer Devices" {
+		t.Errorf("Get(): got Name %q, want %q", got.Name, "HomeSeer Devices")
+	}
+	if calls != 3 {
+		t.Errorf("calls: got %d, want 3", calls)
+	}
+}
+
+func TestClientDoesNotRetryAuthFailures(t *testing.T) {
+	noSleep(t)
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if u, p, ok := req.BasicAuth(); !ok || u != "user" || p != "wrong" {
+			t.Errorf("BasicAuth(): got %q, %q, %v, want user, wrong, true", u, p, ok)
+		}
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	c, err := NewClient(ClientOptions{
+		BaseURL:  srv.URL,
+		Username: "user",
+		Password: "wrong",
+		Retries:  5,
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatalf("Get(): got nil error, want non-nil")
+	}
+	if calls != 1 {
+		t.Errorf("calls: got %d, want 1", calls)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	noSleep(t)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	c, err := NewClient(ClientOptions{
+		BaseURL: srv.URL,
+		Timeout: 10 * time.Millisecond,
+		Retries: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	start := time.Now()
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatalf("Get(): got nil error, want timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Get() took %s, want it bounded by Timeout", elapsed)
+	}
+}
+
+func TestNewClientRejectsUsernameWithoutPassword(t *testing.T) {
+	if _, err := NewClient(ClientOptions{BaseURL: "http://addr", Username: "user"}); err == nil {
+		t.Errorf("NewClient(): got nil error, want non-nil")
+	}
+}
+
+func TestClientBaseURLPathPrefix(t *testing.T) {
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotPath = req.URL.Path
+		_, _ = rw.Write([]byte(`{"Name":"HomeSeer Devices","Devices":[]}`))
+	}))
+	defer srv.Close()
+	c, err := NewClient(ClientOptions{BaseURL: srv.URL + "/homeseer/"})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Fatalf("Get(): got %v, want nil error", err)
+	}
+	if want := "/homeseer/JSON"; gotPath != want {
+		t.Errorf("path: got %q, want %q", gotPath, want)
+	}
+}
+
+func TestNewClientRejectsBadBaseURL(t *testing.T) {
+	for _, in := range []string{"", "127.0.0.1:8080", "ftp://host", "http://"} {
+		if _, err := NewClient(ClientOptions{BaseURL: in}); err == nil {
+			t.Errorf("NewClient(BaseURL: %q): got nil error, want non-nil", in)
+		}
+	}
+}
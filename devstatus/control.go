@@ -0,0 +1,162 @@
+package devstatus
+
+import (
+	"context"
+	"strconv"
+)
+
+// ControlUse is HomeSeer's hint about what a ControlPair does.
+type ControlUse int
+
+const (
+	ControlUseNotSpecified ControlUse = 0
+	ControlUseOn           ControlUse = 1
+	ControlUseOff          ControlUse = 2
+	ControlUseDim          ControlUse = 3
+	ControlUseOnAlternate  ControlUse = 4
+	ControlUseDoorLock     ControlUse = 18
+	ControlUseDoorUnlock   ControlUse = 19
+)
+
+// ControlReport is the response to /JSON?request=getcontrol.
+type ControlReport struct {
+	Name     string
+	Version  string
+	Devices  []DeviceControl
+	Response string
+}
+
+// DeviceControl holds the value to label mapping for one device.
+type DeviceControl struct {
+	Reference    int           `json:"ref"`
+	Name         string        `json:"name"`
+	ControlPairs []ControlPair `json:"ControlPairs"`
+}
+
+// ControlPair labels either a single value or, when Range is set, a range of values.
+type ControlPair struct {
+	Label        string        `json:"Label"`
+	ControlValue float64       `json:"ControlValue"`
+	ControlUse   ControlUse    `json:"ControlUse"`
+	ControlType  int           `json:"ControlType"`
+	Range        *ControlRange `json:"Range"`
+}
+
+// ControlRange labels every value from RangeStart to RangeEnd inclusive.
+type ControlRange struct {
+	RangeStart        float64 `json:"RangeStart"`
+	RangeEnd          float64 `json:"RangeEnd"`
+	RangeStatusPrefix string  `json:"RangeStatusPrefix"`
+	RangeStatusSuffix string  `json:"RangeStatusSuffix"`
+}
+
+// GetControl retrieves the ControlPairs of every device from the given HS3 instance over plain http.
+// It is a convenience wrapper around Client for callers that make a single request.
+func GetControl(hostPort string, username string, password string) (*ControlReport, error) {
+	c, err := NewClient(ClientOptions{
+		BaseURL:  "http://" + hostPort,
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.GetControl(context.Background())
+}
+
+// GetControl retrieves the ControlPairs of every device from HomeSeer.
+func (c *Client) GetControl(ctx context.Context) (*ControlReport, error) {
+	var rval *ControlReport
+	err := c.fetch(ctx, c.jsonURL("getcontrol"), func(payload []byte) (err error) {
+		rval, err = parseControl(payload)
+		return err
+	})
+	return rval, err
+}
+
+func parseControl(payload []byte) (*ControlReport, error) {
+	rval := &ControlReport{}
+	if err := decodeResponse(payload, rval); err != nil {
+		return nil, err
+	}
+	if err := checkResponse(rval.Response); err != nil {
+		return nil, err
+	}
+	return rval, nil
+}
+
+// ByReference indexes the report's devices by their reference number.
+func (r *ControlReport) ByReference() map[int]DeviceControl {
+	rval := make(map[int]DeviceControl, len(r.Devices))
+	for _, d := range r.Devices {
+		rval[d.Reference] = d
+	}
+	return rval
+}
+
+// Label returns the state name HomeSeer shows for value, such as "Open".
+// Single-value pairs take precedence over ranges.
+func (dc DeviceControl) Label(value float64) (string, bool) {
+	if p, ok := dc.pair(value); ok {
+		return p.Label, true
+	}
+	for _, p := range dc.ControlPairs {
+		if r := p.Range; r != nil && value >= r.RangeStart && value <= r.RangeEnd {
+			return r.RangeStatusPrefix + strconv.FormatFloat(value, 'f', -1, 64) + r.RangeStatusSuffix, true
+		}
+	}
+	return "", false
+}
+
+// StateName returns the name of value's state, such as "Open", if a
+// single-value pair labels it.  Unlike Label it ignores ranges, whose
+// labels change with every value in them.
+func (dc DeviceControl) StateName(value float64) (string, bool) {
+	if p, ok := dc.pair(value); ok {
+		return p.Label, true
+	}
+	return "", false
+}
+
+// Binary maps value to 0 or 1 for devices with exactly two states.
+// It uses ControlUse when HomeSeer provides it, and otherwise treats the
+// lower of the two values as 0.  ok is false when the device is not binary
+// or value is not one of its states.
+func (dc DeviceControl) Binary(value float64) (float64, bool) {
+	p, ok := dc.pair(value)
+	if !ok {
+		return 0, false
+	}
+	switch p.ControlUse {
+	case ControlUseOn, ControlUseOnAlternate, ControlUseDoorLock:
+		return 1, true
+	case ControlUseOff, ControlUseDoorUnlock:
+		return 0, true
+	}
+	var singles []ControlPair
+	for _, c := range dc.ControlPairs {
+		if c.Range == nil {
+			singles = append(singles, c)
+		}
+	}
+	if len(singles) != 2 {
+		return 0, false
+	}
+	other := singles[0]
+	if other.ControlValue == value {
+		other = singles[1]
+	}
+	if value > other.ControlValue {
+		return 1, true
+	}
+	return 0, true
+}
+
+func (dc DeviceControl) pair(value float64) (ControlPair, bool) {
+	for _, p := range dc.ControlPairs {
+		if p.Range == nil && p.ControlValue == value {
+			return p, true
+		}
+	}
+	return ControlPair{}, false
+}
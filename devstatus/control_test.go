@@ -0,0 +1,116 @@
+package devstatus
+
+import (
+	"testing"
+)
+
+const controlFixture = `{"Name":"HomeSeer Devices","Version":"1.0","Devices":[
+{"ref":10,"name":"Garage Door","ControlPairs":[
+  {"Do_Update":true,"SingleRangeEntry":true,"Label":"Closed","ControlValue":0,"ControlUse":0,"ControlType":5,"Range":null},
+  {"Do_Update":true,"SingleRangeEntry":true,"Label":"Open","ControlValue":255,"ControlUse":0,"ControlType":5,"Range":null}]},
+{"ref":11,"name":"Lamp","ControlPairs":[
+  {"Label":"On","ControlValue":255,"ControlUse":1,"ControlType":5,"Range":null},
+  {"Label":"Off","ControlValue":0,"ControlUse":2,"ControlType":5,"Range":null},
+  {"Label":"Last Level","ControlValue":99,"ControlUse":4,"ControlType":5,"Range":null},
+  {"Label":"Dim (value)%","ControlValue":0,"ControlUse":3,"ControlType":7,
+   "Range":{"RangeStart":1,"RangeEnd":98,"RangeStatusPrefix":"Dim ","RangeStatusSuffix":"%"}}]}
+]}`
+
+func TestParseControl(t *testing.T) {
+	got, err := parseControl([]byte(controlFixture))
+	if err != nil {
+		t.Fatalf("parseControl(): %v", err)
+	}
+	byRef := got.ByReference()
+	if len(byRef) != 2 {
+		t.Fatalf("ByReference(): got %d devices, want 2", len(byRef))
+	}
+	lamp := byRef[11]
+	if len(lamp.ControlPairs) != 4 || lamp.ControlPairs[3].Range == nil || lamp.ControlPairs[3].Range.RangeEnd != 98 {
+		t.Errorf("lamp ControlPairs: got %+v", lamp.ControlPairs)
+	}
+}
+
+func TestControlLabel(t *testing.T) {
+	report, err := parseControl([]byte(controlFixture))
+	if err != nil {
+		t.Fatalf("parseControl(): %v", err)
+	}
+	byRef := report.ByReference()
+	for _, tc := range []struct {
+		ref    int
+		value  float64
+		want   string
+		wantOK bool
+	}{
+		{10, 0, "Closed", true},
+		{10, 255, "Open", true},
+		{10, 7, "", false},
+		{11, 0, "Off", true},
+		{11, 50, "Dim 50%", true},
+		{11, 99, "Last Level", true},
+	} {
+		got, ok := byRef[tc.ref].Label(tc.value)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("ref %d Label(%v): got %q, %v, want %q, %v", tc.ref, tc.value, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestControlStateName(t *testing.T) {
+	report, err := parseControl([]byte(controlFixture))
+	if err != nil {
+		t.Fatalf("parseControl(): %v", err)
+	}
+	byRef := report.ByReference()
+	for _, tc := range []struct {
+		ref    int
+		value  float64
+		want   string
+		wantOK bool
+	}{
+		{10, 255, "Open", true},
+		{10, 7, "", false},
+		{11, 0, "Off", true},
+		{11, 50, "", false},
+		{11, 99, "Last Level", true},
+	} {
+		got, ok := byRef[tc.ref].StateName(tc.value)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("ref %d StateName(%v): got %q, %v, want %q, %v", tc.ref, tc.value, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestControlBinary(t *testing.T) {
+	report, err := parseControl([]byte(controlFixture))
+	if err != nil {
+		t.Fatalf("parseControl(): %v", err)
+	}
+	byRef := report.ByReference()
+	for _, tc := range []struct {
+		ref    int
+		value  float64
+		want   float64
+		wantOK bool
+	}{
+		{10, 0, 0, true},
+		{10, 255, 1, true},
+		{10, 7, 0, false},
+		{11, 255, 1, true},
+		{11, 99, 1, true},
+		{11, 0, 0, true},
+		{11, 50, 0, false},
+	} {
+		got, ok := byRef[tc.ref].Binary(tc.value)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("ref %d Binary(%v): got %v, %v, want %v, %v", tc.ref, tc.value, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestParseControlHomeseerError(t *testing.T) {
+	if _, err := parseControl([]byte(`{"Response":"Error, bad request"}`)); err == nil {
+		t.Errorf("parseControl(): got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,99 @@
+package devstatus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rawStatusReport defers decoding of each device so one bad device does not
+// spoil the rest of the report.
+type rawStatusReport struct {
+	Name     string
+	Version  string
+	Devices  []json.RawMessage
+	Response string
+}
+
+// DeviceError describes a device from a getstatus response that could not be decoded.
+type DeviceError struct {
+	// Index is the position of the device in the response.
+	Index int
+	// Reference and Name are filled in when they could be decoded.
+	Reference int
+	Name      string
+	Err       error
+}
+
+func (e DeviceError) Error() string {
+	return fmt.Sprintf("device %d (ref %d, %q): %v", e.Index, e.Reference, e.Name, e.Err)
+}
+
+func (e DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// decodeDevice decodes a single device.  On error the returned Device holds
+// whatever fields could be decoded, for use in error reports.
+func decodeDevice(msg json.RawMessage) (Device, error) {
+	var d Device
+	if err := json.Unmarshal(msg, &d); err != nil {
+		return d, err
+	}
+	lc, err := convertLastChange(d.LastChangeDate)
+	if err != nil {
+		return d, err
+	}
+	d.LastChange = lc
+	return d, nil
+}
+
+// UnmarshalJSON decodes a device, accepting the numeric fields HomeSeer
+// sometimes sends as strings.
+func (d *Device) UnmarshalJSON(b []byte) error {
+	type plain Device
+	aux := struct {
+		*plain
+		Reference lenientNumber `json:"ref"`
+		Value     lenientNumber `json:"value"`
+	}{plain: (*plain)(d)}
+	err := json.Unmarshal(b, &aux)
+	d.Reference = int(aux.Reference)
+	d.Value = float64(aux.Value)
+	if err != nil {
+		return err
+	}
+	if float64(d.Reference) != float64(aux.Reference) {
+		return fmt.Errorf("ref %v is not an integer", float64(aux.Reference))
+	}
+	return nil
+}
+
+// lenientNumber decodes a JSON number or a string holding one.
+type lenientNumber float64
+
+func (n *lenientNumber) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("value %q is not a number", s)
+		}
+		*n = lenientNumber(f)
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*n = lenientNumber(f)
+	return nil
+}
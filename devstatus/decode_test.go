@@ -0,0 +1,61 @@
+package devstatus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseStatusSkipsBadDevices(t *testing.T) {
+	payload := []byte(`{"Name":"HomeSeer Devices","Version":"1.0","Devices":[
+{"ref":1,"name":"Good","value":72.5,"last_change":"/Date(1463147447280)/"},
+{"ref":2,"name":"Bad Date","value":1,"last_change":"yesterday"},
+{"ref":3,"name":"String Value","value":"42.5","last_change":"/Date(1463147447280-0500)/"},
+{"ref":4,"name":"Bad Value","value":"Open","last_change":"/Date(1463147447280)/"},
+{"ref":"5","name":"String Ref","value":0,"last_change":"/Date(1463147447280)/"}
+]}`)
+	got, err := parseStatus(payload)
+	if err != nil {
+		t.Fatalf("parseStatus(): got %v, want nil error", err)
+	}
+	var refs []int
+	for _, d := range got.Devices {
+		refs = append(refs, d.Reference)
+	}
+	if len(refs) != 3 || refs[0] != 1 || refs[1] != 3 || refs[2] != 5 {
+		t.Errorf("parseStatus(): got device refs %v, want [1 3 5]", refs)
+	}
+	if len(got.Devices) > 1 && got.Devices[1].Value != 42.5 {
+		t.Errorf("parseStatus(): got string value decoded as %v, want 42.5", got.Devices[1].Value)
+	}
+	if len(got.Errors) != 2 {
+		t.Fatalf("parseStatus(): got %d errors, want 2: %v", len(got.Errors), got.Errors)
+	}
+	for i, want := range []DeviceError{
+		{Index: 1, Reference: 2, Name: "Bad Date"},
+		{Index: 3, Reference: 4, Name: "Bad Value"},
+	} {
+		e := got.Errors[i]
+		if e.Index != want.Index || e.Reference != want.Reference || e.Name != want.Name || e.Err == nil {
+			t.Errorf("Errors[%d]: got %+v, want %+v with non-nil Err", i, e, want)
+		}
+	}
+}
+
+func TestDeviceErrorUnwraps(t *testing.T) {
+	cause := errors.New("gremlins")
+	var err error = DeviceError{Index: 7, Reference: 12, Name: "Lamp", Err: cause}
+	if !errors.Is(err, cause) {
+		t.Errorf("errors.Is(%v, cause): got false, want true", err)
+	}
+	want := `device 7 (ref 12, "Lamp"): gremlins`
+	if err.Error() != want {
+		t.Errorf("Error(): got %q, want %q", err.Error(), want)
+	}
+}
+
+func TestParseStatusHomeseerError(t *testing.T) {
+	_, err := parseStatus([]byte(`{"Response":"Error, bad request"}`))
+	if err == nil {
+		t.Errorf("parseStatus(): got nil error, want non-nil")
+	}
+}
@@ -1,94 +1,76 @@
-// Package devstatus retrieves details about HomeSeer devices from the /JSON?request=getstatus interface.
+// Package devstatus retrieves details about HomeSeer devices from the /JSON interface.
 package devstatus
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"github.com/golang/glog"
-	"io/ioutil"
-	"net/http"
 	"regexp"
 	"strconv"
-	"strings"
 	"time"
 )
 
-var httpgetwithbasicauth = getWithBasicAuth
-
 func basicAuth(username string, password string) string {
 	token := fmt.Sprintf("%s:%s", username, password)
 	return fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(token)))
 }
 
-// 2tatusCodeError signals a non-200 response.
-type statusCodeError struct {
-	url  string
-	code int
-}
-
-func (s statusCodeError) Error() string {
-	return fmt.Sprintf("http.Get(%q): got code %d, want 200", s.url, s.code)
-}
-
-
-func getWithBasicAuth(url string, username string, password string) ([]byte, error) {
-	client := &http.Client{
-	}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	if username != "" {
-		req.Header.Add("Authorization", basicAuth(username, password))
-	}
-	r, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	if r.StatusCode != 200 {
-		return nil, statusCodeError{url: url, code: r.StatusCode}
-	}
-	defer func() {
-		if err := r.Body.Close(); err != nil {
-			glog.Errorf("r.Body.Close(): %v", err)
-		}
-	}()
-	body, err := ioutil.ReadAll(r.Body)
+// Get retrieves all devices from the given HS3 instance over plain http.
+// It is a convenience wrapper around Client for callers that make a single request.
+func Get(hostPort string, username string, password string) (*StatusReport, error) {
+	c, err := NewClient(ClientOptions{
+		BaseURL:  "http://" + hostPort,
+		Username: username,
+		Password: password,
+	})
 	if err != nil {
 		return nil, err
 	}
-	return body, nil
+	return c.Get(context.Background())
 }
 
-// Get retrieves all devices from the given HS3 instance.
-func Get(hostPort string, username string, password string) (*StatusReport, error) {
-	url := fmt.Sprintf("http://%s/JSON?request=getstatus", hostPort)
-	payload, err := httpgetwithbasicauth(url, username, password)
-	if err != nil {
+// parseStatus decodes a getstatus response.  Devices that cannot be decoded
+// are left out of Devices and described in Errors instead of failing the
+// whole report.
+func parseStatus(payload []byte) (*StatusReport, error) {
+	raw := &rawStatusReport{}
+	if err := decodeResponse(payload, raw); err != nil {
 		return nil, err
 	}
-	rval := &StatusReport{}
-	err = json.NewDecoder(bytes.NewReader(payload)).Decode(rval)
-	if err != nil {
+	if err := checkResponse(raw.Response); err != nil {
 		return nil, err
 	}
-	if strings.HasPrefix(rval.Response, "Error") {
-		return nil, fmt.Errorf("homeseer error: %q", rval.Response)
+	rval := &StatusReport{
+		Name:     raw.Name,
+		Version:  raw.Version,
+		Response: raw.Response,
+		Size:     len(payload),
 	}
-	if err := rval.convertLastChange(); err != nil {
-		return nil, err
+	for i, msg := range raw.Devices {
+		d, err := decodeDevice(msg)
+		if err != nil {
+			rval.Errors = append(rval.Errors, DeviceError{
+				Index:     i,
+				Reference: d.Reference,
+				Name:      d.Name,
+				Err:       err,
+			})
+			continue
+		}
+		rval.Devices = append(rval.Devices, d)
 	}
 	return rval, nil
 }
 
-var lastChangePattern = regexp.MustCompile(`^/Date\((-?\d+)([+\\-]\d+)?\)/$`)
+// lastChangePattern matches the Microsoft JSON date format HomeSeer uses:
+// milliseconds since the Unix epoch in UTC, optionally with a fractional part,
+// optionally followed by the server's UTC offset as [+-]hhmm.
+var lastChangePattern = regexp.MustCompile(`^/Date\((-?\d+)(?:\.(\d+))?(?:([+-])(\d{2})(\d{2}))?\)/$`)
 
 func convertLastChange(in string) (time.Time, error) {
 	var never time.Time
 	parts := lastChangePattern.FindStringSubmatch(in)
-	if len(parts) != 3 && len(parts) != 2 {
+	if parts == nil {
 		return never, fmt.Errorf("malformed date: %q", in)
 	}
 	ifrm, err := strconv.ParseInt(parts[1], 10, 64)
@@ -100,38 +82,50 @@ func convertLastChange(in string) (time.Time, error) {
 		// It's unclear why, but I don't want to fail the parse over it.
 		return never, nil
 	}
-	// might have the timezone wrong here, but I'm not using it, so I don't care yet.
-	/*tz, err := time.Parse("Mon Jan 2 15:04:05 2006 -0700", "Mon Jan 2 15:04:05 2006 " + parts[2])
-	if err != nil {
-		return never, err
-	}*/
-	base := time.Unix(ifrm/1000, (ifrm%1000) * 1000000) // .In(tz.Location())
-	return base, nil
+	nanos := (ifrm % 1000) * int64(time.Millisecond)
+	if frac := parts[2]; frac != "" {
+		// Sub-millisecond digits; anything past nanoseconds is dropped.
+		frac = (frac + "000000")[:6]
+		sub, err := strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return never, err
+		}
+		nanos += sub
+	}
+	base := time.Unix(ifrm/1000, nanos)
+	if parts[3] == "" {
+		return base, nil
+	}
+	hours, _ := strconv.Atoi(parts[4])
+	minutes, _ := strconv.Atoi(parts[5])
+	if hours > 14 || minutes > 59 {
+		return never, fmt.Errorf("malformed date: %q: bad offset", in)
+	}
+	offset := hours*3600 + minutes*60
+	if parts[3] == "-" {
+		offset = -offset
+	}
+	return base.In(time.FixedZone(parts[3]+parts[4]+parts[5], offset)), nil
 }
 
 type StatusReport struct {
-	Name    string
-	Version string
-	Devices []Device
+	Name     string
+	Version  string
+	Devices  []Device
 	Response string
-}
-
-func (s *StatusReport) convertLastChange() error {
-	for i, d := range s.Devices {
-		lc, err := convertLastChange(d.LastChangeDate)
-		if err != nil {
-			return err
-		}
-		s.Devices[i].LastChange = lc
-	}
-	return nil
+	// Errors describes devices in the response that could not be decoded.
+	// They are not included in Devices.
+	Errors []DeviceError `json:"-"`
+	// Size is the length of the response in bytes.
+	Size int `json:"-"`
 }
 
 type Device struct {
-	Reference  int     `json:"ref"`
-	Name       string  `json:"name"`
-	Location   string  `json:"location"`
-	Location2  string  `json:"location2"`
+	Reference int    `json:"ref"`
+	Name      string `json:"name"`
+	Location  string `json:"location"`
+	Location2 string `json:"location2"`
+	// Value is decoded from either a JSON number or a numeric string.
 	Value      float64 `json:"value"`
 	Status     string  `json:"status"`
 	DeviceType string  `json:"device_type_string"`
@@ -143,6 +137,8 @@ type Device struct {
 	AssociatedDevices []int      `json:"associated_devices"`
 	Type              DeviceType `json:"device_type"`
 	DeviceImage       string     `json:"status_image"`
+	// UserAccess lists the users who may see the device, such as "Any".
+	UserAccess string `json:"UserAccess"`
 }
 
 type DeviceType struct {
@@ -161,3 +157,15 @@ const (
 	Standalone RelType = 3
 	Child      RelType = 4
 )
+
+func (r RelType) String() string {
+	switch r {
+	case RootDevice:
+		return "root"
+	case Standalone:
+		return "standalone"
+	case Child:
+		return "child"
+	}
+	return strconv.Itoa(int(r))
+}
@@ -1,7 +1,9 @@
 package devstatus
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"reflect"
 	"testing"
 	"time"
@@ -14,11 +16,12 @@ func TestFetch(t *testing.T) {
 	defer func() {
 		httpgetwithbasicauth = save
 	}()
-	httpgetwithbasicauth = func(url string, username string, password string) ([]byte, error) {
-		return []byte(`
-{"Name":"HomeSeer Devices","Version":"1.0","Devices":[{"ref":392,"name":"Device Name","location":"Room Name","location2":"1st Floor","value":2000,"status":"Status Text","device_type_string":"Z-Wave Central Scene","last_change":"\/Date(1463147447280)\/","relationship":4,"hide_from_view":false,"associated_devices":[391],"device_type":{"Device_API":4,"Device_API_Description":"Plug-In API","Device_Type":0,"Device_Type_Description":"Plug-In Type 0","Device_SubType":91,"Device_SubType_Description":""},"device_image":"","UserNote":"","UserAccess":"Any","status_image":"/images/HomeSeer/status/Scene-Pressed-1.png"}]}`), nil
+	payload := `
+{"Name":"HomeSeer Devices","Version":"1.0","Devices":[{"ref":392,"name":"Device Name","location":"Room Name","location2":"1st Floor","value":2000,"status":"Status Text","device_type_string":"Z-Wave Central Scene","last_change":"\/Date(1463147447280)\/","relationship":4,"hide_from_view":false,"associated_devices":[391],"device_type":{"Device_API":4,"Device_API_Description":"Plug-In API","Device_Type":0,"Device_Type_Description":"Plug-In Type 0","Device_SubType":91,"Device_SubType_Description":""},"device_image":"","UserNote":"","UserAccess":"Any","status_image":"/images/HomeSeer/status/Scene-Pressed-1.png"}]}`
+	httpgetwithbasicauth = func(ctx context.Context, client *http.Client, url string, username string, password string) ([]byte, error) {
+		return []byte(payload), nil
 	}
-	got, err := Get("", "", "")
+	got, err := Get("addr", "", "")
 	if err != nil {
 		t.Fatalf("Get(): got %v, want nil error", err)
 	}
@@ -48,8 +51,10 @@ func TestFetch(t *testing.T) {
 					SubTypeDescription: "",
 				},
 				DeviceImage: "/images/HomeSeer/status/Scene-Pressed-1.png",
+				UserAccess:  "Any",
 			},
 		},
+		Size: len(payload),
 	}
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Get(): got %s, want %s", spew.Sdump(got), spew.Sdump(want))
@@ -62,7 +67,7 @@ func TestMalformed(t *testing.T) {
 		httpgetwithbasicauth = save
 	}()
 	addr := ""
-	httpgetwithbasicauth = func(url string, username string, password string) ([]byte, error) {
+	httpgetwithbasicauth = func(ctx context.Context, client *http.Client, url string, username string, password string) ([]byte, error) {
 		addr = url
 		return []byte("This is not JSON"), nil
 	}
@@ -82,10 +87,10 @@ func TestGetFail(t *testing.T) {
 	defer func() {
 		httpgetwithbasicauth = save
 	}()
-	httpgetwithbasicauth = func(url string, username string, password string) ([]byte, error) {
+	httpgetwithbasicauth = func(ctx context.Context, client *http.Client, url string, username string, password string) ([]byte, error) {
 		return nil, errors.New("gremlins")
 	}
-	_, err := Get("", "", "")
+	_, err := Get("addr", "", "")
 	wantErr := "gremlins"
 	if err == nil || err.Error() != wantErr {
 		t.Errorf("Get(...): got %v, want %q", err, wantErr)
@@ -115,3 +120,101 @@ func TestConvertLargeNegativeDate(t *testing.T) {
 		t.Errorf("convertLastChange(%q): got %s, _, want %s, _", input, got.Format("Mon Jan 2 15:04:05 -0700 MST 2006"), want.Format("Mon Jan 2 15:04:05 -0700 MST 2006"))
 	}
 }
+
+func TestConvertLastChangeVariants(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		input      string
+		want       time.Time
+		wantOffset int
+		wantErr    bool
+	}{
+		{
+			desc:  "no offset",
+			input: "/Date(1463147447280)/",
+			want:  time.Unix(1463147447, 280000000),
+		},
+		{
+			desc:       "negative offset",
+			input:      "/Date(1613971427719-0500)/",
+			want:       time.Unix(1613971427, 719000000),
+			wantOffset: -5 * 3600,
+		},
+		{
+			desc:       "positive offset with minutes",
+			input:      "/Date(1613971427719+0530)/",
+			want:       time.Unix(1613971427, 719000000),
+			wantOffset: 5*3600 + 30*60,
+		},
+		{
+			desc:  "negative epoch",
+			input: "/Date(-62135596800000-0500)/",
+			want:  time.Time{},
+		},
+		{
+			desc:  "sub-millisecond precision",
+			input: "/Date(1463147447280.5)/",
+			want:  time.Unix(1463147447, 280500000),
+		},
+		{
+			desc:       "sub-millisecond precision with offset",
+			input:      "/Date(1463147447280.123456789+0100)/",
+			want:       time.Unix(1463147447, 280123456),
+			wantOffset: 3600,
+		},
+		{
+			desc:    "escaped slashes left in",
+			input:   `\/Date(1463147447280)\/`,
+			wantErr: true,
+		},
+		{
+			desc:    "bad offset",
+			input:   "/Date(1463147447280+0075)/",
+			wantErr: true,
+		},
+		{
+			desc:    "short offset",
+			input:   "/Date(1463147447280+05)/",
+			wantErr: true,
+		},
+		{
+			desc:    "empty",
+			input:   "",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := convertLastChange(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("convertLastChange(%q): got %s, nil, want error", tc.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convertLastChange(%q): got _, %v, want _, nil", tc.input, err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("convertLastChange(%q): got %s, want %s", tc.input, got.Format(time.RFC3339Nano), tc.want.Format(time.RFC3339Nano))
+			}
+			if tc.wantOffset != 0 {
+				if _, offset := got.Zone(); offset != tc.wantOffset {
+					t.Errorf("convertLastChange(%q): got zone offset %d, want %d", tc.input, offset, tc.wantOffset)
+				}
+			}
+		})
+	}
+}
+
+func TestRelTypeString(t *testing.T) {
+	for rel, want := range map[RelType]string{
+		RootDevice: "root",
+		Standalone: "standalone",
+		Child:      "child",
+		RelType(0): "0",
+	} {
+		if got := rel.String(); got != want {
+			t.Errorf("RelType(%d).String(): got %q, want %q", int(rel), got, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package devstatus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors classify failures talking to HomeSeer.  Test for them
+// with errors.Is.
+var (
+	// ErrUnreachable means HomeSeer could not be reached or did not answer in time.
+	ErrUnreachable = errors.New("homeseer is unreachable")
+	// ErrUnauthorized means HomeSeer rejected the username or password.
+	ErrUnauthorized = errors.New("homeseer rejected the credentials")
+	// ErrJSONDisabled means HomeSeer answered with HTML instead of JSON,
+	// which it does when "Enable Control with JSON" is turned off.
+	ErrJSONDisabled = errors.New("homeseer returned HTML instead of JSON; is Enable Control with JSON turned on?")
+	// ErrMalformed means the response could not be decoded.
+	ErrMalformed = errors.New("malformed homeseer response")
+	// ErrCircuitOpen means the request was not sent because recent requests
+	// failed and the Client's circuit breaker is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+// StatusCodeError signals a non-200 HTTP response.  401 and 403 responses
+// also match ErrUnauthorized.
+type StatusCodeError struct {
+	URL  string
+	Code int
+}
+
+func (s *StatusCodeError) Error() string {
+	return fmt.Sprintf("http.Get(%q): got code %d, want 200", s.URL, s.Code)
+}
+
+// Is matches ErrUnauthorized for 401 and 403 responses.
+func (s *StatusCodeError) Is(target error) bool {
+	return target == ErrUnauthorized && (s.Code == http.StatusUnauthorized || s.Code == http.StatusForbidden)
+}
+
+// ResponseError carries an error HomeSeer reported in the Response field
+// of an otherwise well formed JSON reply.
+type ResponseError struct {
+	Response string
+}
+
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("homeseer error: %q", r.Response)
+}
+
+// classified attaches one of the sentinel errors to an underlying cause,
+// so errors.Is matches the sentinel and errors.As still finds the cause.
+type classified struct {
+	kind error
+	err  error
+}
+
+func (c classified) Error() string {
+	return c.err.Error()
+}
+
+func (c classified) Is(target error) bool {
+	return target == c.kind
+}
+
+func (c classified) Unwrap() error {
+	return c.err
+}
+
+// Reason returns a short, stable name for the kind of err, suitable for a
+// metric label.
+func Reason(err error) string {
+	var re *ResponseError
+	var se *StatusCodeError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrJSONDisabled):
+		return "json_disabled"
+	case errors.Is(err, ErrUnreachable):
+		return "unreachable"
+	case errors.As(err, &re):
+		return "homeseer_error"
+	case errors.As(err, &se):
+		return "http_status"
+	case errors.Is(err, ErrMalformed):
+		return "malformed"
+	}
+	return "other"
+}
+
+// decodeResponse decodes a JSON reply from HomeSeer into v, classifying
+// failures.
+func decodeResponse(payload []byte, v interface{}) error {
+	if trimmed := bytes.TrimSpace(payload); len(trimmed) > 0 && trimmed[0] == '<' {
+		return classified{kind: ErrJSONDisabled, err: ErrJSONDisabled}
+	}
+	if err := json.NewDecoder(bytes.NewReader(payload)).Decode(v); err != nil {
+		return classified{kind: ErrMalformed, err: err}
+	}
+	return nil
+}
+
+// checkResponse returns a *ResponseError if response reports an error.
+func checkResponse(response string) error {
+	if strings.HasPrefix(response, "Error") {
+		return &ResponseError{Response: response}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package devstatus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeffbstewart/homeseer_exporter/hstest"
+)
+
+func TestErrorTaxonomy(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer s.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	garbage := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("This is not JSON"))
+	}))
+	defer garbage.Close()
+
+	for _, tc := range []struct {
+		desc       string
+		baseURL    string
+		password   string
+		setup      func()
+		wantIs     error
+		wantReason string
+	}{
+		{
+			desc:       "bad password",
+			baseURL:    s.URL,
+			password:   "wrong",
+			wantIs:     ErrUnauthorized,
+			wantReason: "unauthorized",
+		},
+		{
+			desc:       "json disabled",
+			baseURL:    s.URL,
+			password:   "secret",
+			setup:      s.DisableJSON,
+			wantIs:     ErrJSONDisabled,
+			wantReason: "json_disabled",
+		},
+		{
+			desc:     "homeseer error",
+			baseURL:  s.URL,
+			password: "secret",
+			setup: func() {
+				s.RespondWithError("Error, bad request")
+			},
+			wantReason: "homeseer_error",
+		},
+		{
+			desc:       "unreachable",
+			baseURL:    closed.URL,
+			password:   "secret",
+			wantIs:     ErrUnreachable,
+			wantReason: "unreachable",
+		},
+		{
+			desc:       "server error",
+			baseURL:    broken.URL,
+			password:   "secret",
+			wantReason: "http_status",
+		},
+		{
+			desc:       "malformed",
+			baseURL:    garbage.URL,
+			password:   "secret",
+			wantIs:     ErrMalformed,
+			wantReason: "malformed",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			s.Heal()
+			if tc.setup != nil {
+				tc.setup()
+			}
+			c, err := NewClient(ClientOptions{BaseURL: tc.baseURL, Username: "prometheus", Password: tc.password})
+			if err != nil {
+				t.Fatalf("NewClient(): %v", err)
+			}
+			_, err = c.Get(context.Background())
+			if err == nil {
+				t.Fatalf("Get(): got nil error, want non-nil")
+			}
+			if tc.wantIs != nil && !errors.Is(err, tc.wantIs) {
+				t.Errorf("errors.Is(%v, %v): got false, want true", err, tc.wantIs)
+			}
+			if got := Reason(err); got != tc.wantReason {
+				t.Errorf("Reason(%v): got %q, want %q", err, got, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer s.Close()
+	c, err := NewClient(ClientOptions{BaseURL: s.URL, Username: "prometheus", Password: "wrong"})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	_, err = c.Get(context.Background())
+	var se *StatusCodeError
+	if !errors.As(err, &se) || se.Code != http.StatusUnauthorized {
+		t.Errorf("errors.As(%v, *StatusCodeError): got %+v, want code 401", err, se)
+	}
+
+	s.RespondWithError("Error, bad request")
+	c, err = NewClient(ClientOptions{BaseURL: s.URL, Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	_, err = c.Get(context.Background())
+	var re *ResponseError
+	if !errors.As(err, &re) || re.Response != "Error, bad request" {
+		t.Errorf("errors.As(%v, *ResponseError): got %+v, want Response %q", err, re, "Error, bad request")
+	}
+}
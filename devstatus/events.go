@@ -0,0 +1,57 @@
+package devstatus
+
+import (
+	"context"
+)
+
+// EventReport is the response to /JSON?request=getevents.
+type EventReport struct {
+	Name     string
+	Version  string
+	Events   []Event
+	Response string
+}
+
+// Event is a HomeSeer event: a named automation with triggers and actions.
+type Event struct {
+	ID                  int    `json:"id"`
+	Group               string `json:"Group"`
+	Name                string `json:"Name"`
+	VoiceCommand        string `json:"voice_command"`
+	VoiceCommandEnabled bool   `json:"voice_command_enabled"`
+}
+
+// GetEvents retrieves all events from the given HS3 instance over plain http.
+// It is a convenience wrapper around Client for callers that make a single request.
+func GetEvents(hostPort string, username string, password string) (*EventReport, error) {
+	c, err := NewClient(ClientOptions{
+		BaseURL:  "http://" + hostPort,
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.GetEvents(context.Background())
+}
+
+// GetEvents retrieves all events from HomeSeer.
+func (c *Client) GetEvents(ctx context.Context) (*EventReport, error) {
+	var rval *EventReport
+	err := c.fetch(ctx, c.jsonURL("getevents"), func(payload []byte) (err error) {
+		rval, err = parseEvents(payload)
+		return err
+	})
+	return rval, err
+}
+
+func parseEvents(payload []byte) (*EventReport, error) {
+	rval := &EventReport{}
+	if err := decodeResponse(payload, rval); err != nil {
+		return nil, err
+	}
+	if err := checkResponse(rval.Response); err != nil {
+		return nil, err
+	}
+	return rval, nil
+}
@@ -0,0 +1,49 @@
+package devstatus
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+func TestGetEvents(t *testing.T) {
+	save := httpgetwithbasicauth
+	defer func() {
+		httpgetwithbasicauth = save
+	}()
+	addr := ""
+	httpgetwithbasicauth = func(ctx context.Context, client *http.Client, url string, username string, password string) ([]byte, error) {
+		addr = url
+		return []byte(`{"Name":"HomeSeer Events","Version":"1.0","Events":[
+{"Group":"Lighting","Name":"Porch Light On","id":1234,"voice_command":"porch on","voice_command_enabled":true},
+{"Group":"Security","Name":"Arm Away","id":99,"voice_command":"","voice_command_enabled":false}]}`), nil
+	}
+	got, err := GetEvents("addr", "", "")
+	if err != nil {
+		t.Fatalf("GetEvents(): got %v, want nil error", err)
+	}
+	want := &EventReport{
+		Name:    "HomeSeer Events",
+		Version: "1.0",
+		Events: []Event{
+			{ID: 1234, Group: "Lighting", Name: "Porch Light On", VoiceCommand: "porch on", VoiceCommandEnabled: true},
+			{ID: 99, Group: "Security", Name: "Arm Away"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetEvents(): got %s, want %s", spew.Sdump(got), spew.Sdump(want))
+	}
+	wantAddr := "http://addr/JSON?request=getevents"
+	if addr != wantAddr {
+		t.Errorf("GetEvents saw url %q, want %q", addr, wantAddr)
+	}
+}
+
+func TestParseEventsHomeseerError(t *testing.T) {
+	if _, err := parseEvents([]byte(`{"Response":"Error, bad request"}`)); err == nil {
+		t.Errorf("parseEvents(): got nil error, want non-nil")
+	}
+}
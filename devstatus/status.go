@@ -0,0 +1,119 @@
+package devstatus
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Status is a device's status string split into its parts.  "72.5 F"
+// has a Value and a Unit, "Open" only a State, and "Dim 50%" all three.
+type Status struct {
+	// State is the text before any number, or the whole status if it has
+	// no number.
+	State string
+	// Value is the number in the status, if HasValue.
+	Value    float64
+	HasValue bool
+	// Unit is the text after the number.  Units in statusUnits are
+	// replaced by their canonical names, such as "F" for "°F" and "kWh"
+	// for "kW Hours"; other single words are left as they are.  A status
+	// with anything else after its number is all State.
+	Unit string
+}
+
+// KnownUnit reports whether Unit is one of the canonical units.
+func (s Status) KnownUnit() bool {
+	return canonicalUnits[s.Unit]
+}
+
+// statusUnits maps the units homeseer and its plug-ins show, lower cased,
+// to canonical names.
+var statusUnits = map[string]string{
+	"f":  "F",
+	"°f": "F",
+	"c":  "C",
+	"°c": "C",
+	"%":  "%",
+
+	"w":        "W",
+	"watt":     "W",
+	"watts":    "W",
+	"kw":       "kW",
+	"kwh":      "kWh",
+	"kw hours": "kWh",
+	"kwhours":  "kWh",
+	"wh":       "Wh",
+	"v":        "V",
+	"volt":     "V",
+	"volts":    "V",
+	"a":        "A",
+	"amp":      "A",
+	"amps":     "A",
+	"amperes":  "A",
+
+	"lux":      "lux",
+	"lx":       "lux",
+	"uv":       "UV index",
+	"uv index": "UV index",
+	"ppm":      "ppm",
+	"hpa":      "hPa",
+	"inhg":     "inHg",
+	"mph":      "mph",
+	"km/h":     "km/h",
+}
+
+// canonicalUnits is the set of values in statusUnits.
+var canonicalUnits = func() map[string]bool {
+	rval := make(map[string]bool)
+	for _, u := range statusUnits {
+		rval[u] = true
+	}
+	return rval
+}()
+
+var (
+	statusTags = regexp.MustCompile(`<[^>]*>`)
+	// statusWord matches a unit missing from statusUnits, such as "gallons".
+	statusWord = regexp.MustCompile(`^\pL+$`)
+	// statusNumber matches an optional state, a number that may group
+	// thousands with commas or use a decimal comma, and the rest.
+	statusNumber = regexp.MustCompile(`^(?:(.*?) )?([-+]?(?:\d{1,3}(?:,\d{3})+|\d+)(?:[.,]\d+)?)(?: ?(.*))$`)
+)
+
+// ParseStatusString splits a device's status string.  HTML tags, which
+// some plug-ins wrap statuses in, are dropped.
+func ParseStatusString(status string) Status {
+	status = html.UnescapeString(statusTags.ReplaceAllString(status, " "))
+	status = strings.Join(strings.Fields(status), " ")
+	m := statusNumber.FindStringSubmatch(status)
+	if m == nil {
+		return Status{State: status}
+	}
+	number := m[2]
+	if strings.Count(number, ",") == 1 && !strings.Contains(number, ".") && len(number)-strings.Index(number, ",") != 4 {
+		// A decimal comma, as in "21,5 °C".
+		number = strings.Replace(number, ",", ".", 1)
+	} else {
+		number = strings.ReplaceAll(number, ",", "")
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return Status{State: status}
+	}
+	rval := Status{State: m[1], Value: v, HasValue: true, Unit: m[3]}
+	key := strings.ToLower(strings.Replace(strings.Replace(m[3], "º", "°", 1), "° ", "°", 1))
+	if u, ok := statusUnits[key]; ok {
+		rval.Unit = u
+	} else if m[3] != "" && !statusWord.MatchString(m[3]) {
+		// Not a quantity, such as "12:30 PM" or "Level 3 of 5".
+		return Status{State: status}
+	}
+	return rval
+}
+
+// ParsedStatus returns d.Status split into its parts.
+func (d Device) ParsedStatus() Status {
+	return ParseStatusString(d.Status)
+}
@@ -0,0 +1,48 @@
+package devstatus
+
+import (
+	"testing"
+)
+
+func TestParseStatusString(t *testing.T) {
+	for _, tc := range []struct {
+		status string
+		want   Status
+	}{
+		{status: "72.5 F", want: Status{Value: 72.5, HasValue: true, Unit: "F"}},
+		{status: "21.5 °C", want: Status{Value: 21.5, HasValue: true, Unit: "C"}},
+		{status: "21,5 &deg;C", want: Status{Value: 21.5, HasValue: true, Unit: "C"}},
+		{status: "-3.2° F", want: Status{Value: -3.2, HasValue: true, Unit: "F"}},
+		{status: "45 %", want: Status{Value: 45, HasValue: true, Unit: "%"}},
+		{status: "90%", want: Status{Value: 90, HasValue: true, Unit: "%"}},
+		{status: "1.2 kW", want: Status{Value: 1.2, HasValue: true, Unit: "kW"}},
+		{status: "1,200 W", want: Status{Value: 1200, HasValue: true, Unit: "W"}},
+		{status: "1234.5 kW Hours", want: Status{Value: 1234.5, HasValue: true, Unit: "kWh"}},
+		{status: "120 Volts", want: Status{Value: 120, HasValue: true, Unit: "V"}},
+		{status: "0.5 A", want: Status{Value: 0.5, HasValue: true, Unit: "A"}},
+		{status: "300 Lux", want: Status{Value: 300, HasValue: true, Unit: "lux"}},
+		{status: "Dim 50%", want: Status{State: "Dim", Value: 50, HasValue: true, Unit: "%"}},
+		{status: "7", want: Status{Value: 7, HasValue: true}},
+		{status: "12 gallons", want: Status{Value: 12, HasValue: true, Unit: "gallons"}},
+		{status: "12:30 PM", want: Status{State: "12:30 PM"}},
+		{status: "1.2.3", want: Status{State: "1.2.3"}},
+		{status: "Level 3 of 5", want: Status{State: "Level 3 of 5"}},
+		{status: "Open", want: Status{State: "Open"}},
+		{status: "No Motion", want: Status{State: "No Motion"}},
+		{status: `<img src="/images/on.png"> On`, want: Status{State: "On"}},
+		{status: "", want: Status{}},
+	} {
+		if got := ParseStatusString(tc.status); got != tc.want {
+			t.Errorf("ParseStatusString(%q): got %+v, want %+v", tc.status, got, tc.want)
+		}
+	}
+}
+
+func TestStatusKnownUnit(t *testing.T) {
+	if !ParseStatusString("1.2 kW").KnownUnit() {
+		t.Errorf("KnownUnit(1.2 kW): got false, want true")
+	}
+	if ParseStatusString("12 gallons").KnownUnit() {
+		t.Errorf("KnownUnit(12 gallons): got true, want false")
+	}
+}
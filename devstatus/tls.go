@@ -0,0 +1,51 @@
+package devstatus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions configures how a Client connects to an https HomeSeer,
+// such as one behind a TLS reverse proxy.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of certificate authorities to trust instead of
+	// the system roots.  Empty for the system roots.
+	CAFile string
+	// CertFile and KeyFile hold a PEM client certificate and its key,
+	// presented when the server requests one.  Both or neither must be set.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables verification of the server's certificate.
+	// Only use this for testing.
+	InsecureSkipVerify bool
+}
+
+func tlsConfig(opts TLSOptions) (*tls.Config, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, fmt.Errorf("CertFile and KeyFile must be provided together")
+	}
+	cfg := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,109 @@
+package devstatus
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func newTLSServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{"Name":"HomeSeer Devices","Devices":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTLSCustomCA(t *testing.T) {
+	srv := newTLSServer(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatalf("WriteFile(%q): %v", caFile, err)
+	}
+	c, err := NewClient(ClientOptions{
+		BaseURL: srv.URL,
+		TLS:     TLSOptions{CAFile: caFile},
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Errorf("Get(): got %v, want nil error", err)
+	}
+}
+
+func TestTLSUntrusted(t *testing.T) {
+	srv := newTLSServer(t)
+	c, err := NewClient(ClientOptions{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Errorf("Get(): got nil error, want certificate error")
+	}
+}
+
+func TestTLSUntrustedNotRetried(t *testing.T) {
+	noSleep(t)
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	srv.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	c, err := NewClient(ClientOptions{
+		BaseURL:          srv.URL,
+		Retries:          3,
+		BreakerThreshold: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	_, err = c.Get(context.Background())
+	if err == nil {
+		t.Fatalf("Get(): got nil error, want certificate error")
+	}
+	if errors.Is(err, ErrUnreachable) {
+		t.Errorf("Get(): got %v, want an error other than ErrUnreachable", err)
+	}
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("connections: got %d, want 1", got)
+	}
+	if got := c.BreakerState(); got != BreakerClosed {
+		t.Errorf("BreakerState(): got %v, want %v", got, BreakerClosed)
+	}
+}
+
+func TestTLSInsecureSkipVerify(t *testing.T) {
+	srv := newTLSServer(t)
+	c, err := NewClient(ClientOptions{
+		BaseURL: srv.URL,
+		TLS:     TLSOptions{InsecureSkipVerify: true},
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	if _, err := c.Get(context.Background()); err != nil {
+		t.Errorf("Get(): got %v, want nil error", err)
+	}
+}
+
+func TestTLSCertWithoutKey(t *testing.T) {
+	if _, err := NewClient(ClientOptions{
+		BaseURL: "https://addr",
+		TLS:     TLSOptions{CertFile: "cert.pem"},
+	}); err == nil {
+		t.Errorf("NewClient(): got nil error, want non-nil")
+	}
+}
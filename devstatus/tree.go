@@ -0,0 +1,174 @@
+package devstatus
+
+// Tree indexes devices by reference and by their root/child relationships.
+// HomeSeer groups the devices of one physical device, such as the
+// temperature, humidity and battery of a multisensor, as children of a root
+// device.  A root lists its children in AssociatedDevices, and each child
+// lists its root.
+type Tree struct {
+	devices []Device
+	// index maps a reference to its position in devices.
+	index map[int]int
+	// root maps a child's reference to its root's.
+	root map[int]int
+	// children maps a root's reference to its children's, in report order.
+	children map[int][]int
+}
+
+// Tree builds a Tree of the report's devices.
+func (r *StatusReport) Tree() *Tree {
+	return NewTree(r.Devices)
+}
+
+// NewTree builds a Tree of devices.  Devices whose Relationship is not set,
+// as from older versions of HomeSeer, are treated as children of their
+// associated device if they have exactly one.  Of two such devices that
+// list only each other, the one listed first is the root.
+func NewTree(devices []Device) *Tree {
+	t := &Tree{
+		devices:  devices,
+		index:    make(map[int]int, len(devices)),
+		root:     make(map[int]int),
+		children: make(map[int][]int),
+	}
+	for i, d := range devices {
+		t.index[d.Reference] = i
+	}
+	for _, d := range devices {
+		if d.Relationship == RootDevice || d.Relationship == Standalone {
+			continue
+		}
+		if root, ok := t.findRoot(d); ok {
+			t.root[d.Reference] = root
+		}
+	}
+	// A root may list children that do not list it back.
+	for _, d := range devices {
+		if d.Relationship != RootDevice {
+			continue
+		}
+		for _, ref := range d.AssociatedDevices {
+			if _, ok := t.root[ref]; !ok && ref != d.Reference && t.has(ref) {
+				t.root[ref] = d.Reference
+			}
+		}
+	}
+	for _, d := range devices {
+		if root, ok := t.root[d.Reference]; ok {
+			t.children[root] = append(t.children[root], d.Reference)
+		}
+	}
+	return t
+}
+
+// findRoot returns the reference of d's root, preferring an associated
+// device that is a RootDevice.
+func (t *Tree) findRoot(d Device) (int, bool) {
+	for _, ref := range d.AssociatedDevices {
+		if i, ok := t.index[ref]; ok && t.devices[i].Relationship == RootDevice && ref != d.Reference {
+			return ref, true
+		}
+	}
+	if d.Relationship == Child || len(d.AssociatedDevices) == 1 {
+		for _, ref := range d.AssociatedDevices {
+			if t.has(ref) && ref != d.Reference && !t.rootOf(ref, d) {
+				return ref, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// rootOf reports whether d, whose Relationship may not be set, is the root
+// of the device ref rather than its child.  That is so when ref is a Child
+// listing d, or when neither is marked and they list only each other, in
+// which case the one listed first is taken to be the root.
+func (t *Tree) rootOf(ref int, d Device) bool {
+	if d.Relationship == Child {
+		return false
+	}
+	i := t.index[ref]
+	o := t.devices[i]
+	switch {
+	case o.Relationship == Child:
+		for _, r := range o.AssociatedDevices {
+			if r == d.Reference {
+				return true
+			}
+		}
+		return false
+	case o.Relationship == RootDevice || o.Relationship == Standalone:
+		return false
+	}
+	return len(o.AssociatedDevices) == 1 && o.AssociatedDevices[0] == d.Reference && t.index[d.Reference] < i
+}
+
+func (t *Tree) has(ref int) bool {
+	_, ok := t.index[ref]
+	return ok
+}
+
+// Device returns the device with the given reference.
+func (t *Tree) Device(ref int) (Device, bool) {
+	i, ok := t.index[ref]
+	if !ok {
+		return Device{}, false
+	}
+	return t.devices[i], true
+}
+
+// Root returns the root device of the device with the given reference.  ok
+// is false for roots, standalone devices and unknown references.
+func (t *Tree) Root(ref int) (Device, bool) {
+	root, ok := t.root[ref]
+	if !ok {
+		return Device{}, false
+	}
+	return t.Device(root)
+}
+
+// Children returns the children of the root device with the given
+// reference, in report order.
+func (t *Tree) Children(ref int) []Device {
+	return t.lookup(t.children[ref])
+}
+
+// Siblings returns the other children of the given device's root.
+func (t *Tree) Siblings(ref int) []Device {
+	root, ok := t.root[ref]
+	if !ok {
+		return nil
+	}
+	var rval []Device
+	for _, d := range t.Children(root) {
+		if d.Reference != ref {
+			rval = append(rval, d)
+		}
+	}
+	return rval
+}
+
+// Roots returns the devices that have no root: root devices and
+// standalone devices, in report order.
+func (t *Tree) Roots() []Device {
+	var rval []Device
+	for _, d := range t.devices {
+		if _, ok := t.root[d.Reference]; !ok {
+			rval = append(rval, d)
+		}
+	}
+	return rval
+}
+
+func (t *Tree) lookup(refs []int) []Device {
+	if len(refs) == 0 {
+		return nil
+	}
+	rval := make([]Device, 0, len(refs))
+	for _, ref := range refs {
+		if d, ok := t.Device(ref); ok {
+			rval = append(rval, d)
+		}
+	}
+	return rval
+}
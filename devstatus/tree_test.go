@@ -0,0 +1,65 @@
+package devstatus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func refs(devices []Device) []int {
+	var rval []int
+	for _, d := range devices {
+		rval = append(rval, d.Reference)
+	}
+	return rval
+}
+
+func TestTree(t *testing.T) {
+	r := &StatusReport{
+		Devices: []Device{
+			{Reference: 1, Name: "Multisensor", Relationship: RootDevice, AssociatedDevices: []int{2, 3, 4}},
+			{Reference: 2, Name: "Temperature", Relationship: Child, AssociatedDevices: []int{1}},
+			{Reference: 3, Name: "Battery", Relationship: Child, AssociatedDevices: []int{1}},
+			// Listed by its root, but lists nothing itself.
+			{Reference: 4, Name: "Motion", Relationship: Child},
+			{Reference: 5, Name: "Porch Light", Relationship: Standalone, AssociatedDevices: []int{}},
+			// Older HomeSeers leave Relationship unset, and a root with one
+			// child lists it just as the child lists the root.
+			{Reference: 6, Name: "Thermostat", AssociatedDevices: []int{7}},
+			{Reference: 7, Name: "Setpoint", AssociatedDevices: []int{6}},
+			// Unmarked, but listed by a child that says so.
+			{Reference: 9, Name: "Fan", AssociatedDevices: []int{10}},
+			{Reference: 10, Name: "Fan Speed", Relationship: Child, AssociatedDevices: []int{9}},
+			// A child whose root is missing from the report.
+			{Reference: 8, Name: "Orphan", Relationship: Child, AssociatedDevices: []int{99}},
+		},
+	}
+	tree := r.Tree()
+	for ref, want := range map[int]int{2: 1, 3: 1, 4: 1, 7: 6, 10: 9} {
+		if got, ok := tree.Root(ref); !ok || got.Reference != want {
+			t.Errorf("Root(%d): got %d, %v, want %d, true", ref, got.Reference, ok, want)
+		}
+	}
+	for _, ref := range []int{1, 5, 6, 8, 9, 99} {
+		if got, ok := tree.Root(ref); ok {
+			t.Errorf("Root(%d): got %d, want none", ref, got.Reference)
+		}
+	}
+	if got, want := refs(tree.Children(1)), []int{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Children(1): got %v, want %v", got, want)
+	}
+	if got := refs(tree.Children(5)); got != nil {
+		t.Errorf("Children(5): got %v, want none", got)
+	}
+	if got, want := refs(tree.Siblings(3)), []int{2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Siblings(3): got %v, want %v", got, want)
+	}
+	if got, want := refs(tree.Children(6)), []int{7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Children(6): got %v, want %v", got, want)
+	}
+	if got, want := refs(tree.Roots()), []int{1, 5, 6, 9, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Roots(): got %v, want %v", got, want)
+	}
+	if d, ok := tree.Device(7); !ok || d.Name != "Setpoint" {
+		t.Errorf("Device(7): got %q, %v, want Setpoint, true", d.Name, ok)
+	}
+}
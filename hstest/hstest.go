@@ -0,0 +1,182 @@
+// Package hstest provides a fake HomeSeer 4 JSON interface for tests.
+//
+// The fake serves /JSON?request=getstatus, getcontrol and getevents from
+// fixture files, enforces basic auth when credentials are configured, and
+// can be told to fail the way a real HomeSeer does.
+package hstest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//go:embed fixtures/*.json
+var builtin embed.FS
+
+// Requests lists the JSON requests the fake serves.  Each has a fixture
+// file named after it, such as getstatus.json.
+var Requests = []string{"getstatus", "getcontrol", "getevents"}
+
+// JSONDisabledPage is the HTML HomeSeer returns in place of JSON when
+// "Enable Control with JSON" is turned off.
+const JSONDisabledPage = `<!DOCTYPE html>
+<html>
+<head><title>HomeSeer</title></head>
+<body>
+<h1>Error</h1>
+<p>JSON control is disabled. Enable it in Setup -&gt; Network.</p>
+</body>
+</html>
+`
+
+// Options configures a Server.
+type Options struct {
+	// Username and Password, if non empty, must be presented with basic
+	// auth on every request.
+	Username string
+	Password string
+	// FixtureDir, if non empty, is a directory of fixture files that replace
+	// the built-in ones.  Requests without a file there use the built-in
+	// fixture.
+	FixtureDir string
+}
+
+// failure selects how the fake misbehaves.
+type failure int
+
+const (
+	healthy failure = iota
+	homeseerError
+	jsonDisabled
+)
+
+// Server is a fake HomeSeer 4.  It is safe for concurrent use.
+type Server struct {
+	// URL is the base URL of the fake, suitable for devstatus.ClientOptions.BaseURL.
+	URL string
+
+	srv  *httptest.Server
+	opts Options
+
+	mu       sync.Mutex
+	fixtures map[string][]byte
+	failure  failure
+	response string
+	requests map[string]int
+}
+
+// NewServer starts a fake HomeSeer.  Call Close when done.
+func NewServer(opts Options) (*Server, error) {
+	s := &Server{
+		opts:     opts,
+		fixtures: make(map[string][]byte),
+		requests: make(map[string]int),
+	}
+	for _, r := range Requests {
+		payload, err := builtin.ReadFile("fixtures/" + r + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if opts.FixtureDir != "" {
+			override, err := ioutil.ReadFile(filepath.Join(opts.FixtureDir, r+".json"))
+			switch {
+			case err == nil:
+				payload = override
+			case !os.IsNotExist(err):
+				return nil, err
+			}
+		}
+		s.fixtures[r] = payload
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s, nil
+}
+
+// Close shuts down the fake.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetFixture replaces the payload served for request, such as "getstatus".
+func (s *Server) SetFixture(request string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures[request] = payload
+}
+
+// RespondWithError makes every following request return HomeSeer's error
+// payload, {"Response":response}.  response should begin with "Error".
+func (s *Server) RespondWithError(response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = homeseerError
+	s.response = response
+}
+
+// DisableJSON makes every following request return JSONDisabledPage, as
+// HomeSeer does when its JSON interface is turned off.
+func (s *Server) DisableJSON() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = jsonDisabled
+}
+
+// Heal undoes RespondWithError and DisableJSON.
+func (s *Server) Heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = healthy
+}
+
+// RequestCount returns how many times request has been served, including
+// failures.  Requests rejected for bad credentials are not counted.
+func (s *Server) RequestCount(request string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[request]
+}
+
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if s.opts.Username != "" {
+		u, p, ok := req.BasicAuth()
+		if !ok || u != s.opts.Username || p != s.opts.Password {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="HomeSeer"`)
+			http.Error(rw, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if req.URL.Path != "/JSON" {
+		http.NotFound(rw, req)
+		return
+	}
+	request := req.URL.Query().Get("request")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[request]++
+	switch s.failure {
+	case jsonDisabled:
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(rw, JSONDisabledPage)
+		return
+	case homeseerError:
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(struct{ Response string }{s.response})
+		return
+	}
+	payload, ok := s.fixtures[request]
+	if !ok {
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(rw, `{"Response":"Error, invalid request"}`)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(payload)
+}
@@ -0,0 +1,127 @@
+package hstest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+	"github.com/jeffbstewart/homeseer_exporter/hstest"
+)
+
+func newClient(t *testing.T, s *hstest.Server, username string, password string) *devstatus.Client {
+	c, err := devstatus.NewClient(devstatus.ClientOptions{
+		BaseURL:  s.URL,
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): %v", err)
+	}
+	return c
+}
+
+func TestServesFixtures(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewServer(): %v", err)
+	}
+	defer s.Close()
+	c := newClient(t, s, "prometheus", "secret")
+	ctx := context.Background()
+	st, err := c.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if len(st.Devices) != 6 || len(st.Errors) != 0 {
+		t.Errorf("Get(): got %d devices and errors %v, want 6 devices and no errors", len(st.Devices), st.Errors)
+	}
+	cr, err := c.GetControl(ctx)
+	if err != nil {
+		t.Fatalf("GetControl(): %v", err)
+	}
+	if label, _ := cr.ByReference()[200].Label(255); label != "On" {
+		t.Errorf("GetControl(): got label %q for ref 200, want On", label)
+	}
+	er, err := c.GetEvents(ctx)
+	if err != nil {
+		t.Fatalf("GetEvents(): %v", err)
+	}
+	if len(er.Events) != 2 {
+		t.Errorf("GetEvents(): got %d events, want 2", len(er.Events))
+	}
+	for _, r := range hstest.Requests {
+		if got := s.RequestCount(r); got != 1 {
+			t.Errorf("RequestCount(%q): got %d, want 1", r, got)
+		}
+	}
+}
+
+func TestEnforcesBasicAuth(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewServer(): %v", err)
+	}
+	defer s.Close()
+	for _, pass := range []string{"", "wrong"} {
+		user := "prometheus"
+		if pass == "" {
+			user = ""
+		}
+		if _, err := newClient(t, s, user, pass).Get(context.Background()); err == nil {
+			t.Errorf("Get() with password %q: got nil error, want non-nil", pass)
+		}
+	}
+	if got := s.RequestCount("getstatus"); got != 0 {
+		t.Errorf("RequestCount(): got %d, want 0", got)
+	}
+}
+
+func TestFailureModes(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{})
+	if err != nil {
+		t.Fatalf("NewServer(): %v", err)
+	}
+	defer s.Close()
+	c := newClient(t, s, "", "")
+	ctx := context.Background()
+
+	s.RespondWithError("Error, JSON is not enabled")
+	if _, err := c.Get(ctx); err == nil || !strings.Contains(err.Error(), "Error, JSON is not enabled") {
+		t.Errorf("Get() after RespondWithError: got %v, want the homeseer error", err)
+	}
+	s.DisableJSON()
+	if _, err := c.Get(ctx); err == nil {
+		t.Errorf("Get() after DisableJSON: got nil error, want non-nil")
+	}
+	s.Heal()
+	if _, err := c.Get(ctx); err != nil {
+		t.Errorf("Get() after Heal: got %v, want nil error", err)
+	}
+}
+
+func TestFixtureDir(t *testing.T) {
+	dir := t.TempDir()
+	payload := `{"Name":"HomeSeer Devices","Version":"1.0","Devices":[]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "getstatus.json"), []byte(payload), 0600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	s, err := hstest.NewServer(hstest.Options{FixtureDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer(): %v", err)
+	}
+	defer s.Close()
+	c := newClient(t, s, "", "")
+	st, err := c.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	if len(st.Devices) != 0 {
+		t.Errorf("Get(): got %d devices, want 0 from the override", len(st.Devices))
+	}
+	if _, err := c.GetEvents(context.Background()); err != nil {
+		t.Errorf("GetEvents(): got %v, want the built-in fixture", err)
+	}
+}
@@ -10,27 +10,109 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
 	"github.com/jeffbstewart/homeseer_exporter/prometheusbridge"
 )
 
 var (
-	hs4       = flag.String("hs4", "127.0.0.1:8080", "host:port for the homeseer to export")
+	hs4URL    = flag.String("hs4_url", "http://127.0.0.1:8080", "base URL of the homeseer to export, including scheme and any path prefix")
+	caFile    = flag.String("ca_file", "", "if non empty, a PEM bundle of certificate authorities to trust for an https homeseer")
+	certFile  = flag.String("cert_file", "", "if non empty, a PEM client certificate to present to an https homeseer")
+	keyFile   = flag.String("key_file", "", "if non empty, the PEM key for --cert_file")
+	insecure  = flag.Bool("insecure_skip_verify", false, "disable verification of the homeseer's TLS certificate; for testing only")
 	port      = flag.Int("port", 6789, "TCP port to export the exporter on")
+	namespace = flag.String("namespace", "homeseer", "prefix for the name of every metric, as in homeseer_up")
 	user      = flag.String("user", "", "if non empty, the username to present to homeseer")
 	pass      = flag.String("pass", "", "if non empty, the password to present to homeseer")
 	location1 = flag.String("location1", "room", "prometheus label for Location1")
 	location2 = flag.String("location2", "floor", "prometheus label for Location2")
+	timeout   = flag.Duration("timeout", 10*time.Second, "maximum time to wait for each request to homeseer")
+	retries   = flag.Int("retries", 2, "number of times to retry a request to homeseer that fails transiently")
+	breakAt   = flag.Int("breaker_threshold", 0, "if non zero, stop sending requests to homeseer after this many fail in a row, backing off exponentially")
+	breakFor  = flag.Duration("breaker_cooldown", 5*time.Second, "how long to first stop sending requests for when --breaker_threshold trips")
+	minPoll   = flag.Duration("min_refresh_interval", 5*time.Second, "scrapes sooner than this after the last poll of homeseer are served its values instead of polling again")
+	pollEvery = flag.Duration("poll_interval", 0, "if non zero, poll homeseer in the background this often and serve scrapes from the last poll")
+	ascii     = flag.String("ascii", "", "if non empty, host[:port] of homeseer's ASCII interface, used to export device changes as they happen")
+	probeCfg  = flag.String("probe_config", "", "if non empty, a JSON file of homeseers to serve at /probe?target=<name> instead of polling --hs4_url")
+	rules     = flag.String("rules", "", "if non empty, a JSON file of rules mapping devices to metrics, replacing the built-in rules")
+	dvAll     = flag.Bool("device_value", false, "export every device's value as device_value, whether or not a rule exports it")
+	dvHidden  = flag.Bool("device_value_skip_hidden", false, "leave devices hidden from view out of device_value")
+	dvRoots   = flag.Bool("device_value_skip_roots", false, "leave root devices out of device_value")
+	tempUnit  = flag.String("temperature_unit", "fahrenheit", "celsius or fahrenheit, the unit temperatures are converted to and named for")
+	legacyF   = flag.Bool("temperature_legacy_degreesf", false, "with --temperature_unit=celsius, also export temperatures in Fahrenheit as temperature_degreesf while dashboards move over")
+	refLabel  = flag.Bool("ref_label", false, "add a ref label holding each device's reference number, so devices with the same name and location do not collide")
+	eventsFor = flag.Duration("event_refresh_interval", 0, "if non zero, the shortest time between fetches of homeseer's events; zero fetches them on every poll")
+	maxStale  = flag.Duration("max_staleness", 0, "if non zero, keep serving the last device values read for up to this long while homeseer cannot be read")
+	grace     = flag.Duration("stale_grace_period", 0, "how long a device that disappears from homeseer, or is renamed, keeps exporting its last value")
 )
 
+// deviceRules are loaded from --rules, or nil for the built-in rules.
+var deviceRules []prometheusbridge.Rule
+
 func main() {
 	flag.Parse()
+	if *namespace == "" {
+		// Unprefixed, homeseer's up would clash with the up Prometheus records for every target.
+		glog.Fatalf("--namespace must not be empty")
+	}
+	if *rules != "" {
+		r, err := prometheusbridge.LoadRules(*rules)
+		if err != nil {
+			glog.Fatalf("prometheusbridge.LoadRules: %v", err)
+		}
+		deviceRules = r
+	}
+	if *probeCfg != "" {
+		probe()
+	} else {
+		single()
+	}
+	fp := fmt.Sprintf(":%d", *port)
+	glog.Infof("Serving HTTP at %s", fp)
+	if err := http.ListenAndServe(fp, nil); err != nil {
+		glog.Fatalf("http.ListenAndServer(%q): %v", fp, err)
+	}
+}
+
+// single exports the homeseer at --hs4_url on /metrics.
+func single() {
 	if err := prometheusbridge.New(prometheusbridge.Options{
-		HostPort: *hs4,
-		Username: *user,
-		Password: *pass,
+		Namespace: *namespace,
+		BaseURL:   *hs4URL,
+		TLS: devstatus.TLSOptions{
+			CAFile:             *caFile,
+			CertFile:           *certFile,
+			KeyFile:            *keyFile,
+			InsecureSkipVerify: *insecure,
+		},
+		Username:           *user,
+		Password:           *pass,
+		Timeout:            *timeout,
+		Retries:            *retries,
+		BreakerThreshold:   *breakAt,
+		BreakerCooldown:    *breakFor,
+		MinRefreshInterval: *minPoll,
+		PollInterval:       *pollEvery,
+		ASCIIAddress:       *ascii,
+		StaleGracePeriod:   *grace,
+		MaxStaleness:       *maxStale,
+		Rules:              deviceRules,
+		RefLabel:           *refLabel,
+		Temperature: prometheusbridge.TemperatureOptions{
+			Unit:           *tempUnit,
+			LegacyDegreesF: *legacyF,
+		},
+		DeviceValue: prometheusbridge.DeviceValueOptions{
+			Enabled:    *dvAll,
+			SkipHidden: *dvHidden,
+			SkipRoots:  *dvRoots,
+		},
+		EventRefreshInterval: *eventsFor,
 		OnError: func(err error) {
 			glog.Errorf("prometheusbridge: %v", err)
 		},
@@ -39,9 +121,42 @@ func main() {
 	}); err != nil {
 		glog.Fatalf("prometheusbridge.New: %v", err)
 	}
-	fp := fmt.Sprintf(":%d", *port)
-	glog.Infof("Serving HTTP at %s", fp)
-	if err := http.ListenAndServe(fp, nil); err != nil {
-		glog.Fatalf("http.ListenAndServer(%q): %v", fp, err)
+}
+
+// probe serves the homeseers in --probe_config on /probe, and the
+// exporter's own metrics on /metrics.
+func probe() {
+	cfg, err := prometheusbridge.LoadProbeConfig(*probeCfg)
+	if err != nil {
+		glog.Fatalf("prometheusbridge.LoadProbeConfig: %v", err)
+	}
+	p, err := prometheusbridge.NewProber(cfg, prometheusbridge.Options{
+		Namespace:          *namespace,
+		Timeout:            *timeout,
+		Retries:            *retries,
+		BreakerThreshold:   *breakAt,
+		BreakerCooldown:    *breakFor,
+		MinRefreshInterval: *minPoll,
+		StaleGracePeriod:   *grace,
+		MaxStaleness:       *maxStale,
+		Rules:              deviceRules,
+		RefLabel:           *refLabel,
+		Location1:          *location1,
+		Location2:          *location2,
+		Temperature: prometheusbridge.TemperatureOptions{
+			Unit:           *tempUnit,
+			LegacyDegreesF: *legacyF,
+		},
+		DeviceValue: prometheusbridge.DeviceValueOptions{
+			Enabled:    *dvAll,
+			SkipHidden: *dvHidden,
+			SkipRoots:  *dvRoots,
+		},
+		EventRefreshInterval: *eventsFor,
+	})
+	if err != nil {
+		glog.Fatalf("prometheusbridge.NewProber: %v", err)
 	}
+	http.Handle("/probe", p)
+	http.Handle("/metrics", promhttp.Handler())
 }
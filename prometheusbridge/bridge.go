@@ -3,6 +3,8 @@
 package prometheusbridge
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -16,102 +18,87 @@ import (
 )
 
 var (
-	devstatusget = devstatus.Get
-	register     = prometheus.Register
-	handle       = http.Handle
+	devstatusget        = (*devstatus.Client).Get
+	devstatusgetcontrol = (*devstatus.Client).GetControl
+	devstatusgetevents  = (*devstatus.Client).GetEvents
+	handle              = http.Handle
 )
 
-func gaugeOpts(opts Options, name string, help string) prometheus.GaugeOpts {
-	return prometheus.GaugeOpts{
-		Namespace: opts.Namespace,
-		Subsystem: opts.Subsystem,
-		Name:      name,
-		Help:      help,
-	}
-}
-
-func newGaugeVec(opts Options, name string, help string) (*prometheus.GaugeVec, error) {
-	r := prometheus.NewGaugeVec(
-		gaugeOpts(opts, name, help),
-		[]string{
-			opts.Location2,
-			opts.Location1,
-			"device",
-			"parentDevice",
-		})
-	if err := register(r); err != nil {
-		return nil, err
-	}
-	return r, nil
-}
-
-func now(opts Options) (prometheus.Gauge, error) {
-	r := prometheus.NewGauge(gaugeOpts(opts, "current_unix_time", "seconds elapsed since Jan 1, 1970 UTC"))
-	return r, register(r)
-}
-
-func lastUpdateUnixTime(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "last_update_unix_time",
-		"Seconds since Jan 1, 1970 UTC when this device last received an update")
-}
-
-func temperature(opts Options) (*prometheus.GaugeVec, error) {
-	// TODO(jeffstewart): deal with units.
-	return newGaugeVec(opts, "temperature_degreesf",
-		"A temperature reading in degrees Fahrenheit")
-}
-
-func relativeHumidity(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "relative_humidity_percent", "Relative Humidity, 0 to 100%")
-}
-
-func luminance(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "luminance_lux", "A measure of light intensity")
-}
-
-func battery(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "battery_percent", "Percent of charge remaining in a battery")
-}
-
-func watts(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "power_watts", "Instantaneous power consumption")
-}
-
-func kwhours(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "cumulative_power_kwhours", "Total power consumption over time")
-}
-
-func ultraviolet(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "ultraviolet_index", "A measure of ultraviolet light exposure")
-}
-
-func sensorBinary(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "sensor_binary", "A sensor that can be either on or off")
-}
-func switchBinary(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "switch_binary", "A switch that is either on or off")
-}
-
-func switchMultilevel(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "switch_multilevel", "A dimmable switch")
-}
-
-func volts(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "potential_volts", "A measure of electrical potential")
-}
-
-func amperes(opts Options) (*prometheus.GaugeVec, error) {
-	return newGaugeVec(opts, "current_amperes", "Instantaneous electrical current")
+// metadataRefresh is how often device ControlPairs are refetched.  They
+// only change when homeseer is reconfigured, so there is no need to fetch
+// them on every scrape.
+const metadataRefresh = 10 * time.Minute
+
+func scrapeFailures(opts Options) (*prometheus.CounterVec, error) {
+	r := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "scrape_failures_total",
+			Help:      "Failed requests to homeseer, by request and reason",
+		},
+		[]string{"request", "reason"})
+	return r, opts.Registerer.Register(r)
 }
 
 // Options configures the exporter
 type Options struct {
-	// HostPort is the host:port of the homeseer 4 server.
-	HostPort string
+	// BaseURL locates the homeseer 4 server, including scheme and any path
+	// prefix.  Example: "http://127.0.0.1:8080"
+	BaseURL string
+	// TLS configures connections when BaseURL uses https.
+	TLS devstatus.TLSOptions
 	// Username is the identity to present as authentication.  Empty for none.
 	Username string
 	// Password is the credential to present as authentication.
 	Password string
+	// Timeout bounds each request to homeseer.  Zero means devstatus.DefaultTimeout.
+	Timeout time.Duration
+	// Retries is the number of times a transiently failing request is retried.
+	Retries int
+	// BreakerThreshold, if non zero, is the number of consecutive failed
+	// requests that stop requests to homeseer for BreakerCooldown, doubling
+	// while it keeps failing.  See devstatus.ClientOptions.
+	BreakerThreshold int
+	// BreakerCooldown is how long requests first stop for.  Zero means
+	// devstatus.DefaultBreakerCooldown.
+	BreakerCooldown time.Duration
+	// MinRefreshInterval, if non zero, is the shortest time between polls
+	// triggered by scrapes.  Scrapes that arrive sooner are served the
+	// previous poll's values.
+	MinRefreshInterval time.Duration
+	// PollInterval, if non zero, polls homeseer in the background this
+	// often, and scrapes are served from the most recent poll instead of
+	// polling homeseer themselves.
+	PollInterval time.Duration
+	// ASCIIAddress, if non empty, is the host[:port] of homeseer's ASCII
+	// interface.  Device changes it reports are exported as they happen,
+	// between polls.
+	ASCIIAddress string
+	// EventRefreshInterval, if non zero, is the shortest time between
+	// fetches of homeseer's events.  Zero refetches them on every poll, so
+	// renamed and deleted events are seen at once.
+	EventRefreshInterval time.Duration
+	// MaxStaleness, if non zero, keeps serving the last successful poll
+	// when homeseer cannot be read, until the poll is this old.
+	// up is still 0 and staleness_seconds says how old it is.
+	MaxStaleness time.Duration
+	// StaleGracePeriod is how long a device that disappears from homeseer,
+	// or is renamed, keeps exporting its last value.  Zero drops it at the
+	// next poll.
+	StaleGracePeriod time.Duration
+	// Rules decide which devices are exported, and how.  Nil means
+	// DefaultRules.
+	Rules []Rule
+	// Temperature sets the unit rules with the temperature transform export.
+	Temperature TemperatureOptions
+	// RefLabel adds a "ref" label, holding the device's reference number, to
+	// every device's series.  Devices with the same name and location then
+	// no longer collide.
+	RefLabel bool
+	// DeviceValue configures device_value, which exports every
+	// device whether or not Rules match it.
+	DeviceValue DeviceValueOptions
 	// OnError will be informed of fatal errors.
 	OnError func(error)
 	// Namespace metrics will be exported under
@@ -119,6 +106,15 @@ type Options struct {
 	// Subsystem metrics will be exportered under
 	Subsystem string
 
+	// Registerer, if set, is where metrics are registered instead of
+	// prometheus.DefaultRegisterer.  The monitor's handlers are then left
+	// for the caller to mount; see Monitor.Handler.
+	Registerer prometheus.Registerer
+	// Gatherer is what Monitor.Handler serves.  If nil, it is Registerer,
+	// which must then also be a prometheus.Gatherer such as a
+	// *prometheus.Registry.
+	Gatherer prometheus.Gatherer
+
 	// Location1 will be the namespace key in prometheus for HS4's Location1.
 	// Example: "floor"
 	Location1 string
@@ -128,75 +124,153 @@ type Options struct {
 	Location2 string
 }
 
+// DeviceValueOptions configures device_value.
+type DeviceValueOptions struct {
+	// Enabled exports device_value.
+	Enabled bool
+	// SkipHidden leaves out devices hidden from view in homeseer.
+	SkipHidden bool
+	// SkipRoots leaves out root devices, which group child devices.
+	SkipRoots bool
+}
+
 // New creates and starts a monitor for the given target.
 // onError will be called if monitoring the target fails.
 // onError will be called only once.
 func New(opts Options) error {
-	_, err := internalNew(opts)
+	_, err := Start(opts)
 	return err
 }
 
 func internalNew(opts Options) (*monitor, error) {
-	if opts.Username != "" && opts.Password == "" {
-		return nil, fmt.Errorf("when Username is provided you must also provide a password")
-	}
 	if opts.Location2 == opts.Location1 {
 		return nil, fmt.Errorf("options Location1 cannot be the same as Location2")
 	}
-	glog.Infof("Monitoring homeseer at %s", opts.HostPort)
+	client, err := devstatus.NewClient(devstatus.ClientOptions{
+		BaseURL:          opts.BaseURL,
+		TLS:              opts.TLS,
+		Username:         opts.Username,
+		Password:         opts.Password,
+		Timeout:          opts.Timeout,
+		Retries:          opts.Retries,
+		BreakerThreshold: opts.BreakerThreshold,
+		BreakerCooldown:  opts.BreakerCooldown,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Without a Registerer, keep to the default registry and mux.
+	global := opts.Registerer == nil
+	promHandler := promhttp.Handler()
+	if global {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+	if opts.Gatherer == nil && !global {
+		g, ok := opts.Registerer.(prometheus.Gatherer)
+		if !ok {
+			return nil, fmt.Errorf("options Gatherer is required when Registerer is not a prometheus.Gatherer")
+		}
+		opts.Gatherer = g
+	}
+	if opts.Gatherer != nil {
+		promHandler = promhttp.HandlerFor(opts.Gatherer, promhttp.HandlerOpts{})
+	}
+	glog.Infof("Monitoring homeseer at %s", opts.BaseURL)
 	rval := &monitor{
 		opts:        opts,
-		close:       make(chan interface{}, 1),
-		promHandler: promhttp.Handler(),
+		client:      client,
+		close:       make(chan interface{}),
+		pulse:       make(chan interface{}, 1),
+		promHandler: promHandler,
 	}
-
-	var err error
-	if rval.temperature, err = temperature(opts); err != nil {
+	if rval.collector, err = newCollector(rval); err != nil {
 		return nil, err
 	}
-	if rval.relativeHumidity, err = relativeHumidity(opts); err != nil {
-		return nil, err
+	rval.ctx, rval.cancel = context.WithCancel(context.Background())
+	// Undo a New that fails part way, so it can be retried with the same
+	// Registerer.
+	reg := &undoRegisterer{Registerer: opts.Registerer}
+	regOpts := opts
+	regOpts.Registerer = reg
+	err = rval.register(regOpts)
+	if err == nil && opts.ASCIIAddress != "" {
+		err = rval.startWatcher()
 	}
-	if rval.luminance, err = luminance(opts); err != nil {
+	if err != nil {
+		rval.cancel()
+		reg.undo()
 		return nil, err
 	}
-	if rval.battery, err = battery(opts); err != nil {
-		return nil, err
+	if opts.PollInterval > 0 {
+		rval.startPoller()
 	}
-	if rval.watts, err = watts(opts); err != nil {
-		return nil, err
+	if global {
+		handle("/", http.RedirectHandler("/metrics", 302))
+		handle("/metrics", rval)
 	}
-	if rval.kwhours, err = kwhours(opts); err != nil {
-		return nil, err
+	return rval, nil
+}
+
+// register creates the monitor's metrics and registers them, along with
+// its collector, with opts.Registerer.
+func (m *monitor) register(opts Options) error {
+	var err error
+	if err = opts.Registerer.Register(m.collector); err != nil {
+		return err
 	}
-	if rval.ultraviolet, err = ultraviolet(opts); err != nil {
-		return nil, err
+	if m.valueChanges, err = valueChanges(opts); err != nil {
+		return err
 	}
-	if rval.sensorBinary, err = sensorBinary(opts); err != nil {
-		return nil, err
+	if m.scrapeFailures, err = scrapeFailures(opts); err != nil {
+		return err
 	}
-	if rval.switchBinary, err = switchBinary(opts); err != nil {
-		return nil, err
+	if m.staleDeleted, err = staleDeleted(opts); err != nil {
+		return err
 	}
-	if rval.switchMultilevel, err = switchMultilevel(opts); err != nil {
-		return nil, err
+	if m.up, err = up(opts); err != nil {
+		return err
 	}
-	if rval.volts, err = volts(opts); err != nil {
-		return nil, err
+	if m.getstatusDuration, err = getstatusDuration(opts); err != nil {
+		return err
 	}
-	if rval.amperes, err = amperes(opts); err != nil {
-		return nil, err
+	if m.responseSize, err = responseSize(opts); err != nil {
+		return err
 	}
-	if rval.now, err = now(opts); err != nil {
-		return nil, err
+	if m.parseErrors, err = parseErrors(opts); err != nil {
+		return err
 	}
+	m.pollAge, err = pollAge(opts, m)
+	return err
+}
 
-	if rval.lastUpdateUnixTime, err = lastUpdateUnixTime(opts); err != nil {
-		return nil, err
+// undoRegisterer records the collectors it registers, so undo can
+// unregister them.
+type undoRegisterer struct {
+	prometheus.Registerer
+	registered []prometheus.Collector
+}
+
+func (u *undoRegisterer) Register(c prometheus.Collector) error {
+	if err := u.Registerer.Register(c); err != nil {
+		return err
 	}
-	handle("/", http.RedirectHandler("/metrics", 302))
-	handle("/metrics", rval)
-	return rval, nil
+	u.registered = append(u.registered, c)
+	return nil
+}
+
+func (u *undoRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := u.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (u *undoRegisterer) undo() {
+	for _, c := range u.registered {
+		u.Registerer.Unregister(c)
+	}
+	u.registered = nil
 }
 
 // monitor monitors an instance of HS3.
@@ -207,94 +281,182 @@ type monitor struct {
 	wg          sync.WaitGroup
 	promHandler http.Handler
 
-	opts Options
+	opts   Options
+	client *devstatus.Client
+	// ctx is canceled by cancel when the monitor stops, aborting any
+	// background work in flight.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+
+	// pollMu guards inflight, lastAttempt and lastErr, which coalesce scrapes.
+	pollMu      sync.Mutex
+	inflight    *inflight
+	lastAttempt time.Time
+	lastErr     error
+	// mu guards latest, pushed, controls and events, which other goroutines also use.
+	mu sync.Mutex
+	// latest is the last successful poll, or nil before there is one.
+	latest *snapshot
+	// failed is set when the last poll failed, so latest is out of date.
+	failed bool
+	// pushed holds changes from the ASCII interface since latest was fetched, by reference.
+	pushed map[int]devstatus.DeviceChange
+	// controls holds each device's ControlPairs by reference, as of controlsFetched.
+	controls        map[int]devstatus.DeviceControl
+	controlsFetched time.Time
+	events          []devstatus.Event
+	eventsFetched   time.Time
+	// seen holds the devices exported by recent polls, for expire.
+	seen map[seriesKey]seenDevice
+	// collided holds the references of devices lost to collisions in the
+	// last poll, for logCollisions.
+	collided map[int]bool
+	// changed holds the labels of each value_changes_total series, so expire
+	// can delete them once their device is no longer exported.
+	changed map[string][]string
+
+	collector      *collector
+	pollAge        prometheus.GaugeFunc
+	valueChanges   *prometheus.CounterVec
+	scrapeFailures *prometheus.CounterVec
+	staleDeleted   prometheus.Counter
+	// up and the metrics after it describe polls of homeseer.
+	up                prometheus.Gauge
+	getstatusDuration prometheus.Histogram
+	responseSize      prometheus.Gauge
+	parseErrors       *prometheus.CounterVec
+}
+
+// snapshot is one poll of homeseer.  It is not modified once stored, so
+// a scrape that holds one sees a consistent view.
+type snapshot struct {
+	taken    time.Time
+	devices  []devstatus.Device
+	rejected int
+	tree     *devstatus.Tree
+	controls map[int]devstatus.DeviceControl
+	events   []devstatus.Event
+	// units holds the unit each device's status names, by reference,
+	// parsed once per poll.
+	units map[int]string
+	// stale holds devices that have disappeared but are still exported.
+	stale []seenDevice
+	// collisions holds devices lost to later devices with the same labels.
+	collisions []collision
+}
 
-	temperature        *prometheus.GaugeVec
-	relativeHumidity   *prometheus.GaugeVec
-	luminance          *prometheus.GaugeVec
-	battery            *prometheus.GaugeVec
-	watts              *prometheus.GaugeVec
-	kwhours            *prometheus.GaugeVec
-	ultraviolet        *prometheus.GaugeVec
-	sensorBinary       *prometheus.GaugeVec
-	switchBinary       *prometheus.GaugeVec
-	switchMultilevel   *prometheus.GaugeVec
-	volts              *prometheus.GaugeVec
-	amperes            *prometheus.GaugeVec
-	now                prometheus.Gauge
-	lastUpdateUnixTime *prometheus.GaugeVec
+// current returns the latest snapshot and a copy of the changes pushed
+// since.  If the last poll failed, the snapshot is nil unless it is within
+// MaxStaleness, in which case stale is true.
+func (m *monitor) current() (latest *snapshot, pushed map[int]devstatus.DeviceChange, stale bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed {
+		if m.latest == nil || m.opts.MaxStaleness == 0 || time.Since(m.latest.taken) > m.opts.MaxStaleness {
+			return nil, nil, false
+		}
+		stale = true
+	}
+	pushed = make(map[int]devstatus.DeviceChange, len(m.pushed))
+	for ref, dc := range m.pushed {
+		pushed[ref] = dc
+	}
+	return m.latest, pushed, stale
 }
 
 func (m *monitor) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	if err := m.pollOnce(); err != nil {
-		glog.Errorf("pollOnce(): %v", err)
-		rw.WriteHeader(500)
-		return
+	// Without a background poller, each scrape polls homeseer itself.  A
+	// failed poll is still served, so up and the like are seen.
+	if m.opts.PollInterval == 0 {
+		if err := m.refresh(req.Context()); err != nil {
+			glog.Errorf("pollOnce(): %v", err)
+		}
 	}
 	m.promHandler.ServeHTTP(rw, req)
 }
 
-func (m *monitor) pollOnce() error {
-	st, err := devstatusget(m.opts.HostPort, m.opts.Username, m.opts.Password)
+func (m *monitor) pollOnce(ctx context.Context) error {
+	started := time.Now()
+	st, err := devstatusget(m.client, ctx)
+	m.getstatusDuration.Observe(time.Since(started).Seconds())
 	if err != nil {
-		return fmt.Errorf("devstatus.Get(%q, %q, elided): %v", m.opts.HostPort, m.opts.Username, err)
-	}
-	m.now.Set(float64(time.Now().Unix()))
-	want := map[string]*prometheus.GaugeVec{
-		"Z-Wave Temperature":       m.temperature,
-		"Z-Wave Relative Humidity": m.relativeHumidity,
-
-		"Z-Wave Battery": m.battery,
-
-		"Z-Wave Luminance":   m.luminance,
-		"Z-Wave Ultraviolet": m.ultraviolet,
-
-		"Z-Wave Watts":    m.watts,
-		"Z-Wave kW Hours": m.kwhours,
-		"Z-Wave Volts":    m.volts,
-		"Z-Wave Amperes":  m.amperes,
-
-		"Z-Wave Sensor Binary": m.sensorBinary,
-
-		"Z-Wave Switch":            m.switchBinary,
-		"Z-Wave Switch Multilevel": m.switchMultilevel,
-
-		// TODO: Nest special handling
-	}
-	now := time.Now()
-	m.now.Set(float64(now.Unix()))
-	deviceNames := make(map[int]string)
-	for _, d := range st.Devices {
-		deviceNames[d.Reference] = d.Name
-	}
-	for _, d := range st.Devices {
-		device := ""
-		parent := ""
-		t := d.DeviceType
-		if t == "Z-Wave Electric Meter" {
-			t = "Z-Wave " + d.Name
+		m.scrapeFailures.WithLabelValues("getstatus", devstatus.Reason(err)).Inc()
+		if errors.Is(err, devstatus.ErrMalformed) {
+			m.parseErrors.WithLabelValues("response").Inc()
 		}
-
-		if got, ok := want[t]; ok {
-			if d.DeviceType == "Z-Wave Switch Binary" || d.DeviceType == "Z-Wave Switch" {
-				// convert 0/255 to 0/1
-				if d.Value != 0 {
-					d.Value = 1
-				}
-			}
-			device = d.Name
-			if len(d.AssociatedDevices) == 1 {
-				parent = deviceNames[d.AssociatedDevices[0]]
-			}
-			labels := prometheus.Labels{
-				m.opts.Location2: d.Location2,
-				m.opts.Location1: d.Location,
-				"device":         device,
-				"parentDevice":   parent,
-			}
-			got.With(labels).Set(d.Value)
-			m.lastUpdateUnixTime.With(labels).Set(float64(d.LastChange.Unix()))
+		m.up.Set(0)
+		m.mu.Lock()
+		m.failed = true
+		m.mu.Unlock()
+		return fmt.Errorf("devstatus.Get(%q, %q, elided): %w", m.opts.BaseURL, m.opts.Username, err)
+	}
+	for _, e := range st.Errors {
+		glog.V(1).Infof("skipping device: %v", e)
+	}
+	m.parseErrors.WithLabelValues("device").Add(float64(len(st.Errors)))
+	m.responseSize.Set(float64(st.Size))
+	m.up.Set(1)
+	m.refreshControls(ctx)
+	m.refreshEvents(ctx)
+	s := &snapshot{
+		devices:  st.Devices,
+		rejected: len(st.Errors),
+		tree:     st.Tree(),
+		units:    statusUnits(st.Devices),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.taken = time.Now()
+	s.controls = m.controls
+	s.events = m.events
+	m.expire(s)
+	m.logCollisions(s)
+	m.latest = s
+	m.failed = false
+	// The poll already reflects changes pushed before it started.
+	for ref, dc := range m.pushed {
+		if dc.Received.Before(started) {
+			delete(m.pushed, ref)
 		}
 	}
 	return nil
 }
+
+// refreshControls refetches device ControlPairs when they are missing or
+// older than metadataRefresh.  Failures are logged and counted, and the
+// previous ControlPairs, if any, are kept.
+func (m *monitor) refreshControls(ctx context.Context) {
+	if m.controls != nil && time.Since(m.controlsFetched) < metadataRefresh {
+		return
+	}
+	cr, err := devstatusgetcontrol(m.client, ctx)
+	if err != nil {
+		glog.Errorf("devstatus.GetControl(%q, %q, elided): %v", m.opts.BaseURL, m.opts.Username, err)
+		m.scrapeFailures.WithLabelValues("getcontrol", devstatus.Reason(err)).Inc()
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.controls = cr.ByReference()
+	m.controlsFetched = time.Now()
+}
+
+// refreshEvents refetches events when they are missing or older than
+// EventRefreshInterval.  Failures are logged and counted, and the previous
+// events are kept.
+func (m *monitor) refreshEvents(ctx context.Context) {
+	if !m.eventsFetched.IsZero() && time.Since(m.eventsFetched) < m.opts.EventRefreshInterval {
+		return
+	}
+	er, err := devstatusgetevents(m.client, ctx)
+	if err != nil {
+		glog.Errorf("devstatus.GetEvents(%q, %q, elided): %v", m.opts.BaseURL, m.opts.Username, err)
+		m.scrapeFailures.WithLabelValues("getevents", devstatus.Reason(err)).Inc()
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = er.Events
+	m.eventsFetched = time.Now()
+}
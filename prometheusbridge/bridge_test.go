@@ -1,17 +1,61 @@
 package prometheusbridge
 
 import (
+	"context"
 	"errors"
-	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+	"github.com/jeffbstewart/homeseer_exporter/hstest"
 )
 
+// noHandle keeps tests from registering on http.DefaultServeMux, which
+// panics when the same pattern is registered twice.
+func noHandle(t *testing.T) {
+	save := handle
+	t.Cleanup(func() {
+		handle = save
+	})
+	handle = func(string, http.Handler) {}
+}
+
+// stubEvents makes the monitor see er as the getevents response.
+func stubEvents(t *testing.T, er *devstatus.EventReport) {
+	save := devstatusgetevents
+	t.Cleanup(func() {
+		devstatusgetevents = save
+	})
+	devstatusgetevents = func(c *devstatus.Client, ctx context.Context) (*devstatus.EventReport, error) {
+		return er, nil
+	}
+}
+
+// stubControl makes the monitor see cr as the getcontrol response.
+func stubControl(t *testing.T, cr *devstatus.ControlReport) {
+	save := devstatusgetcontrol
+	t.Cleanup(func() {
+		devstatusgetcontrol = save
+	})
+	devstatusgetcontrol = func(c *devstatus.Client, ctx context.Context) (*devstatus.ControlReport, error) {
+		return cr, nil
+	}
+}
+
 func TestPoll(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
 	save := devstatusget
 	defer func() {
 		devstatusget = save
 	}()
-	devstatusget = func(hostPort string, user string, pass string) (*devstatus.StatusReport, error) {
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
 		return &devstatus.StatusReport{
 			Devices: []devstatus.Device{
 				{
@@ -31,6 +75,7 @@ func TestPoll(t *testing.T) {
 	opts := Options{
 		OnError:   onError,
 		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
 		Location1: "Floor",
 		Location2: "Room",
 	}
@@ -38,18 +83,21 @@ func TestPoll(t *testing.T) {
 	if err != nil {
 		t.Fatalf("New: %v", err)
 	}
-	_ = mon.pollOnce()
+	gotErr = mon.pollOnce(context.Background())
 	if gotErr != nil {
 		t.Fatalf("gotErr: got %v, want nil", gotErr)
 	}
 }
 
 func TestPollFails(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
 	save := devstatusget
 	defer func() {
 		devstatusget = save
 	}()
-	devstatusget = func(hostPort string, user string, pass string) (*devstatus.StatusReport, error) {
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
 		return nil, errors.New("gremlins")
 	}
 	var gotErr error
@@ -59,7 +107,7 @@ func TestPollFails(t *testing.T) {
 	opts := Options{
 		OnError:   onError,
 		Namespace: t.Name(),
-		HostPort:  "1.2.3.4:80",
+		BaseURL:   "http://1.2.3.4:80",
 		Username:  "Tim",
 		Password:  "What is your Quest?",
 		Location1: "l1",
@@ -69,9 +117,452 @@ func TestPollFails(t *testing.T) {
 	if err != nil {
 		t.Fatalf("New(): %v", err)
 	}
-	_ = mon.pollOnce()
-	wantErr := `devstatus.Get("1.2.3.4:80", "Tim", elided): gremlins`
+	gotErr = mon.pollOnce(context.Background())
+	wantErr := `devstatus.Get("http://1.2.3.4:80", "Tim", elided): gremlins`
 	if gotErr == nil || gotErr.Error() != wantErr {
 		t.Errorf("gotErr: got\n%v, want\n%s", gotErr, wantErr)
 	}
+	if got := testutil.ToFloat64(mon.scrapeFailures.WithLabelValues("getstatus", "other")); got != 1 {
+		t.Errorf("scrapeFailures{request=getstatus,reason=other}: got %v, want 1", got)
+	}
+}
+
+func TestPollCountsRejectedDevices(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{
+					Name:       "Main Thermostat Temperature",
+					Value:      72.0,
+					DeviceType: "Z-Wave Temperature",
+				},
+			},
+			Errors: []devstatus.DeviceError{
+				{Index: 1, Reference: 7, Name: "Broken", Err: errors.New("gremlins")},
+			},
+		}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP TestPollCountsRejectedDevices_rejected_devices Devices in the last homeseer response that could not be decoded and were not exported
+# TYPE TestPollCountsRejectedDevices_rejected_devices gauge
+TestPollCountsRejectedDevices_rejected_devices 1
+# HELP TestPollCountsRejectedDevices_temperature_degreesf A temperature reading in degrees Fahrenheit
+# TYPE TestPollCountsRejectedDevices_temperature_degreesf gauge
+TestPollCountsRejectedDevices_temperature_degreesf{device="Main Thermostat Temperature",l1="",l2="",parentDevice=""} 72
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestPollCountsRejectedDevices_rejected_devices", "TestPollCountsRejectedDevices_temperature_degreesf"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+}
+
+func TestPollUsesControlPairs(t *testing.T) {
+	noHandle(t)
+	stubEvents(t, &devstatus.EventReport{})
+	stubControl(t, &devstatus.ControlReport{
+		Devices: []devstatus.DeviceControl{
+			{
+				Reference: 10,
+				ControlPairs: []devstatus.ControlPair{
+					{Label: "Closed", ControlValue: 0},
+					{Label: "Open", ControlValue: 255},
+				},
+			},
+			{
+				Reference: 11,
+				ControlPairs: []devstatus.ControlPair{
+					{Label: "Locked", ControlValue: 0, ControlUse: devstatus.ControlUseDoorLock},
+					{Label: "Unlocked", ControlValue: 1, ControlUse: devstatus.ControlUseDoorUnlock},
+				},
+			},
+			{
+				// Ranges have no state name, so the lamp has no device_state.
+				Reference: 12,
+				ControlPairs: []devstatus.ControlPair{
+					{Label: "Off", ControlValue: 0},
+					{Label: "Dim (value)%", Range: &devstatus.ControlRange{
+						RangeStart: 1, RangeEnd: 99, RangeStatusPrefix: "Dim ", RangeStatusSuffix: "%"}},
+				},
+			},
+		},
+	})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 10, Name: "Garage Door", Value: 255, DeviceType: "Z-Wave Sensor Binary"},
+				{Reference: 11, Name: "Front Door", Value: 0, DeviceType: "Z-Wave Switch"},
+				{Reference: 12, Name: "Lamp", Value: 50, DeviceType: "Z-Wave Switch Multilevel"},
+			},
+		}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP TestPollUsesControlPairs_device_state Always 1, labeled with the state name homeseer shows for the device's current value
+# TYPE TestPollUsesControlPairs_device_state gauge
+TestPollUsesControlPairs_device_state{device="Front Door",l1="",l2="",parentDevice="",state="Locked"} 1
+TestPollUsesControlPairs_device_state{device="Garage Door",l1="",l2="",parentDevice="",state="Open"} 1
+# HELP TestPollUsesControlPairs_sensor_binary A sensor that can be either on or off
+# TYPE TestPollUsesControlPairs_sensor_binary gauge
+TestPollUsesControlPairs_sensor_binary{device="Garage Door",l1="",l2="",parentDevice=""} 1
+# HELP TestPollUsesControlPairs_switch_binary A switch that is either on or off
+# TYPE TestPollUsesControlPairs_switch_binary gauge
+TestPollUsesControlPairs_switch_binary{device="Front Door",l1="",l2="",parentDevice=""} 1
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestPollUsesControlPairs_device_state", "TestPollUsesControlPairs_sensor_binary",
+		"TestPollUsesControlPairs_switch_binary"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+}
+
+func TestPollExportsEvents(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	er := &devstatus.EventReport{
+		Events: []devstatus.Event{
+			{ID: 1234, Group: "Lighting", Name: "Porch Light On", VoiceCommand: "porch on"},
+			{ID: 99, Group: "Security", Name: "Arm Away"},
+		},
+	}
+	stubEvents(t, er)
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP TestPollExportsEvents_event_info Always 1, labeled with the details of a homeseer event
+# TYPE TestPollExportsEvents_event_info gauge
+TestPollExportsEvents_event_info{group="Lighting",id="1234",name="Porch Light On",voice_command="porch on"} 1
+TestPollExportsEvents_event_info{group="Security",id="99",name="Arm Away",voice_command=""} 1
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestPollExportsEvents_event_info"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+
+	// A renamed event is seen at the next poll.
+	er.Events = []devstatus.Event{{ID: 99, Group: "Security", Name: "Arm Stay"}}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want = `
+# HELP TestPollExportsEvents_event_info Always 1, labeled with the details of a homeseer event
+# TYPE TestPollExportsEvents_event_info gauge
+TestPollExportsEvents_event_info{group="Security",id="99",name="Arm Stay",voice_command=""} 1
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestPollExportsEvents_event_info"); err != nil {
+		t.Errorf("collector after rename: %v", err)
+	}
+
+	// Failures keep the last events, and are counted so they can be alerted on.
+	devstatusgetevents = func(c *devstatus.Client, ctx context.Context) (*devstatus.EventReport, error) {
+		return nil, errors.New("gremlins")
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestPollExportsEvents_event_info"); err != nil {
+		t.Errorf("collector after failure: %v", err)
+	}
+	if got := testutil.ToFloat64(mon.scrapeFailures.WithLabelValues("getevents", "other")); got != 1 {
+		t.Errorf("scrapeFailures{request=getevents,reason=other}: got %v, want 1", got)
+	}
+}
+
+func TestRemovedDevicesVanish(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devices := []devstatus.Device{
+		{Reference: 1, Name: "Attic", Value: 90, DeviceType: "Z-Wave Temperature"},
+		{Reference: 2, Name: "Basement", Value: 60, DeviceType: "Z-Wave Temperature"},
+	}
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{Devices: devices}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	devices = devices[1:]
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP TestRemovedDevicesVanish_temperature_degreesf A temperature reading in degrees Fahrenheit
+# TYPE TestRemovedDevicesVanish_temperature_degreesf gauge
+TestRemovedDevicesVanish_temperature_degreesf{device="Basement",l1="",l2="",parentDevice=""} 60
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestRemovedDevicesVanish_temperature_degreesf"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+}
+
+func TestParentDeviceIsRoot(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Thermostat", Relationship: devstatus.RootDevice, AssociatedDevices: []int{2, 3}},
+				// Associated with its root and with the scene that controls it.
+				{Reference: 2, Name: "Temperature", Value: 68, Relationship: devstatus.Child,
+					AssociatedDevices: []int{3, 1}, DeviceType: "Z-Wave Temperature"},
+				{Reference: 3, Name: "Scene", Relationship: devstatus.Child, AssociatedDevices: []int{1}},
+			},
+		}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace:  "hs",
+		BaseURL:    "http://127.0.0.1:8080",
+		Registerer: prometheus.NewRegistry(),
+		Location1:  "l1",
+		Location2:  "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP hs_temperature_degreesf A temperature reading in degrees Fahrenheit
+# TYPE hs_temperature_degreesf gauge
+hs_temperature_degreesf{device="Temperature",l1="",l2="",parentDevice="Thermostat"} 68
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want), "hs_temperature_degreesf"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+}
+
+func TestServeHTTPAgainstFakeHomeseer(t *testing.T) {
+	noHandle(t)
+	s, err := hstest.NewServer(hstest.Options{Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer s.Close()
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   s.URL,
+		Username:  "prometheus",
+		Password:  "secret",
+		Location1: "room",
+		Location2: "floor",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	rw := httptest.NewRecorder()
+	mon.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP(): got code %d, want 200", rw.Code)
+	}
+	for _, want := range []string{
+		`TestServeHTTPAgainstFakeHomeseer_temperature_degreesf{device="Temperature",floor="Ground Floor",parentDevice="Multisensor",room="Living Room"} 72.5`,
+		`TestServeHTTPAgainstFakeHomeseer_switch_binary{device="Porch Light",floor="Outside",parentDevice="",room="Porch"} 1`,
+		`TestServeHTTPAgainstFakeHomeseer_event_info{group="Security",id="1002",name="Arm Away",voice_command=""} 1`,
+		`TestServeHTTPAgainstFakeHomeseer_device_info{api="Thermostat API",device="Temperature",device_type="Z-Wave Temperature",floor="Ground Floor",hidden="false",parentDevice="Multisensor",ref="101",relationship="child",room="Living Room",subtype="Temperature",type="Thermostat Temperature",user_access="Any"} 1`,
+	} {
+		if !strings.Contains(rw.Body.String(), want) {
+			t.Errorf("ServeHTTP(): missing %s", want)
+		}
+	}
+}
+
+func TestPrivateRegistries(t *testing.T) {
+	handled := false
+	save := handle
+	defer func() {
+		handle = save
+	}()
+	handle = func(string, http.Handler) {
+		handled = true
+	}
+	s, err := hstest.NewServer(hstest.Options{Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer s.Close()
+	var handlers []http.Handler
+	for _, namespace := range []string{"upstairs", "downstairs"} {
+		mon, err := Start(Options{
+			BaseURL:    s.URL,
+			Username:   "prometheus",
+			Password:   "secret",
+			Registerer: prometheus.NewRegistry(),
+			Namespace:  namespace,
+			Location1:  "room",
+			Location2:  "floor",
+		})
+		if err != nil {
+			t.Fatalf("Start(%s): %v", namespace, err)
+		}
+		defer mon.Stop()
+		handlers = append(handlers, mon.Handler())
+	}
+	if handled {
+		t.Errorf("Start() registered on http.DefaultServeMux with a private Registerer")
+	}
+	for i, namespace := range []string{"upstairs", "downstairs"} {
+		rw := httptest.NewRecorder()
+		handlers[i].ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("ServeHTTP(%s): got code %d, want 200", namespace, rw.Code)
+		}
+		body := rw.Body.String()
+		want := namespace + `_temperature_degreesf{device="Temperature",floor="Ground Floor",parentDevice="Multisensor",room="Living Room"} 72.5`
+		if !strings.Contains(body, want) {
+			t.Errorf("ServeHTTP(%s): missing %s", namespace, want)
+		}
+		if strings.Contains(body, "go_goroutines") {
+			t.Errorf("ServeHTTP(%s): served the default registry", namespace)
+		}
+	}
+}
+
+func TestRegistererWithoutGatherer(t *testing.T) {
+	noHandle(t)
+	_, err := internalNew(Options{
+		BaseURL:    "http://127.0.0.1:8080",
+		Registerer: prometheus.WrapRegistererWithPrefix("hs_", prometheus.NewRegistry()),
+		Location1:  "l1",
+		Location2:  "l2",
+	})
+	if err == nil {
+		t.Errorf("New(): got nil error, want one asking for a Gatherer")
+	}
+}
+
+func TestFailedNewUnregisters(t *testing.T) {
+	noHandle(t)
+	reg := prometheus.NewRegistry()
+	opts := Options{
+		Namespace:  "hs",
+		BaseURL:    "http://127.0.0.1:8080",
+		Registerer: reg,
+		Location1:  "l1",
+		Location2:  "l2",
+	}
+	// Registered already, so New fails after registering its collector and
+	// value_changes_total.
+	blocker, err := scrapeFailures(opts)
+	if err != nil {
+		t.Fatalf("scrapeFailures(): %v", err)
+	}
+	mon, err := internalNew(opts)
+	if err == nil {
+		t.Fatalf("New(): got nil error with scrape_failures_total already registered")
+	}
+	if mon != nil {
+		t.Errorf("New(): got a monitor along with error %v", err)
+	}
+	reg.Unregister(blocker)
+	if _, err := internalNew(opts); err != nil {
+		t.Errorf("New() after a failed New(): %v", err)
+	}
+}
+
+func TestScrapeFailureReasons(t *testing.T) {
+	noHandle(t)
+	s, err := hstest.NewServer(hstest.Options{Username: "prometheus", Password: "secret"})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer s.Close()
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   s.URL,
+		Username:  "prometheus",
+		Password:  "secret",
+		Location1: "room",
+		Location2: "floor",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	s.DisableJSON()
+	if err := mon.pollOnce(context.Background()); !errors.Is(err, devstatus.ErrJSONDisabled) {
+		t.Errorf("pollOnce(): got %v, want ErrJSONDisabled", err)
+	}
+	s.RespondWithError("Error, bad request")
+	_ = mon.pollOnce(context.Background())
+	want := `
+# HELP TestScrapeFailureReasons_scrape_failures_total Failed requests to homeseer, by request and reason
+# TYPE TestScrapeFailureReasons_scrape_failures_total counter
+TestScrapeFailureReasons_scrape_failures_total{reason="homeseer_error",request="getstatus"} 1
+TestScrapeFailureReasons_scrape_failures_total{reason="json_disabled",request="getstatus"} 1
+`
+	if err := testutil.CollectAndCompare(mon.scrapeFailures, strings.NewReader(want)); err != nil {
+		t.Errorf("scrapeFailures: %v", err)
+	}
 }
@@ -0,0 +1,49 @@
+package prometheusbridge
+
+import (
+	"context"
+	"time"
+)
+
+// inflight is a poll that concurrent scrapes share.
+type inflight struct {
+	done chan struct{}
+	// err is set before done is closed.
+	err error
+}
+
+// refresh polls homeseer on behalf of a scrape.  Scrapes that arrive while
+// a poll is in flight wait for it instead of starting another, and scrapes
+// within MinRefreshInterval of the last poll reuse its result.
+func (m *monitor) refresh(ctx context.Context) error {
+	m.pollMu.Lock()
+	if c := m.inflight; c != nil {
+		m.pollMu.Unlock()
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if m.opts.MinRefreshInterval > 0 && !m.lastAttempt.IsZero() && time.Since(m.lastAttempt) < m.opts.MinRefreshInterval {
+		err := m.lastErr
+		m.pollMu.Unlock()
+		return err
+	}
+	c := &inflight{done: make(chan struct{})}
+	m.inflight = c
+	m.pollMu.Unlock()
+
+	// The poll is shared, so it must not be abandoned just because the
+	// scrape that started it went away.  The client's timeout bounds it.
+	c.err = m.pollOnce(m.ctx)
+
+	m.pollMu.Lock()
+	m.inflight = nil
+	m.lastAttempt = time.Now()
+	m.lastErr = c.err
+	m.pollMu.Unlock()
+	close(c.done)
+	return c.err
+}
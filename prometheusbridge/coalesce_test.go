@@ -0,0 +1,129 @@
+package prometheusbridge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+// blockingGet stubs devstatusget with a fetch that blocks until release is
+// closed, counting calls.
+func blockingGet(t *testing.T, calls *int32, release chan struct{}) {
+	save := devstatusget
+	t.Cleanup(func() {
+		devstatusget = save
+	})
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		atomic.AddInt32(calls, 1)
+		<-release
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Name: "Thermostat", Value: 72.0, DeviceType: "Z-Wave Temperature"},
+			},
+		}, nil
+	}
+}
+
+// waitingContext counts calls to Done, which refresh makes only when a
+// scrape starts waiting for a poll already in flight.
+type waitingContext struct {
+	context.Context
+	waiting *int32
+}
+
+func (c waitingContext) Done() <-chan struct{} {
+	atomic.AddInt32(c.waiting, 1)
+	return c.Context.Done()
+}
+
+func TestConcurrentScrapesShareOnePoll(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	var calls int32
+	release := make(chan struct{})
+	blockingGet(t, &calls, release)
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	const scrapes = 5
+	var waiting int32
+	ctx := waitingContext{Context: context.Background(), waiting: &waiting}
+	codes := make([]int, scrapes)
+	var wg sync.WaitGroup
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			mon.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil).WithContext(ctx))
+			codes[i] = rw.Code
+		}(i)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&waiting) < scrapes-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("scrapes never coalesced onto one poll")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("devstatus.Get calls: got %d, want 1", got)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("scrape %d: got code %d, want 200", i, code)
+		}
+	}
+}
+
+func TestMinRefreshInterval(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	var calls int32
+	release := make(chan struct{})
+	close(release)
+	blockingGet(t, &calls, release)
+	mon, err := internalNew(Options{
+		Namespace:          t.Name(),
+		BaseURL:            "http://127.0.0.1:8080",
+		MinRefreshInterval: time.Hour,
+		Location1:          "l1",
+		Location2:          "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := mon.refresh(context.Background()); err != nil {
+			t.Fatalf("refresh(): %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("devstatus.Get calls: got %d, want 1", got)
+	}
+	mon.pollMu.Lock()
+	mon.lastAttempt = time.Now().Add(-2 * time.Hour)
+	mon.pollMu.Unlock()
+	if err := mon.refresh(context.Background()); err != nil {
+		t.Fatalf("refresh(): %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("devstatus.Get calls after the interval: got %d, want 2", got)
+	}
+}
@@ -0,0 +1,349 @@
+package prometheusbridge
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+func deviceLabels(opts Options, extraLabels ...string) []string {
+	labels := []string{
+		opts.Location2,
+		opts.Location1,
+		"device",
+		"parentDevice",
+	}
+	if opts.RefLabel {
+		labels = append(labels, "ref")
+	}
+	return append(labels, extraLabels...)
+}
+
+func newDesc(opts Options, name string, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, name), help, labels, nil)
+}
+
+func newDeviceDesc(opts Options, name string, help string, extraLabels ...string) *prometheus.Desc {
+	return newDesc(opts, name, help, deviceLabels(opts, extraLabels...))
+}
+
+func now(opts Options) *prometheus.Desc {
+	return newDesc(opts, "current_unix_time", "seconds elapsed since Jan 1, 1970 UTC", nil)
+}
+
+func deviceState(opts Options) *prometheus.Desc {
+	return newDeviceDesc(opts, "device_state",
+		"Always 1, labeled with the state name homeseer shows for the device's current value", "state")
+}
+
+func eventInfo(opts Options) *prometheus.Desc {
+	return newDesc(opts, "event_info", "Always 1, labeled with the details of a homeseer event",
+		[]string{"id", "group", "name", "voice_command"})
+}
+
+func deviceValue(opts Options) *prometheus.Desc {
+	return newDesc(opts, "device_value", "The value of a homeseer device, whatever its type",
+		[]string{opts.Location2, opts.Location1, "device", "ref", "device_type"})
+}
+
+// deviceInfo has the device labels of the value series, so it can be
+// joined to them, and always a ref.
+func deviceInfo(opts Options) *prometheus.Desc {
+	extra := []string{"device_type", "api", "type", "subtype", "relationship", "hidden", "user_access"}
+	if !opts.RefLabel {
+		extra = append([]string{"ref"}, extra...)
+	}
+	return newDeviceDesc(opts, "device_info", "Always 1, labeled with the details of a homeseer device", extra...)
+}
+
+func rejectedDevices(opts Options) *prometheus.Desc {
+	return newDesc(opts, "rejected_devices",
+		"Devices in the last homeseer response that could not be decoded and were not exported", nil)
+}
+
+func lastUpdateUnixTime(opts Options) *prometheus.Desc {
+	return newDeviceDesc(opts, "last_update_unix_time",
+		"Seconds since Jan 1, 1970 UTC when this device last received an update")
+}
+
+// seriesKey identifies one series within a scrape.
+type seriesKey struct {
+	desc   *prometheus.Desc
+	labels string
+}
+
+// deviceMetric is how the devices matching a rule are exported.
+type deviceMetric struct {
+	desc *prometheus.Desc
+	// binary maps values to 0 or 1.
+	binary bool
+	// temperature converts values to Celsius if celsius is set, and
+	// otherwise to Fahrenheit.  reports, if set, is the unit devices report
+	// in, overriding their status.
+	temperature bool
+	celsius     bool
+	reports     string
+	// legacy, if set, also exports the value in Fahrenheit.
+	legacy *prometheus.Desc
+	scale  float64
+	offset float64
+}
+
+// value returns the exported value for a device.
+func (dm deviceMetric) value(dc devstatus.DeviceControl, d devstatus.Device, unit string) float64 {
+	v := d.Value
+	if dm.binary {
+		v = binaryValue(dc, v)
+	}
+	if dm.temperature {
+		switch unit := temperatureUnit(dm.reports, unit); {
+		case dm.celsius && unit == "F":
+			v = toCelsius(v)
+		case !dm.celsius && unit == "C":
+			v = toFahrenheit(v)
+		}
+	}
+	return v*dm.scale + dm.offset
+}
+
+// collector builds const metrics from the monitor's latest snapshot each
+// time it is collected, so every scrape sees exactly one poll and devices
+// that disappear from homeseer disappear from the scrape.
+type collector struct {
+	m *monitor
+
+	// rules decide which metric, if any, exports each device.
+	rules []compiledRule
+
+	now                *prometheus.Desc
+	rejectedDevices    *prometheus.Desc
+	lastUpdateUnixTime *prometheus.Desc
+	deviceState        *prometheus.Desc
+	eventInfo          *prometheus.Desc
+	deviceInfo         *prometheus.Desc
+	labelCollisions    *prometheus.Desc
+	allDevices         *prometheus.Desc
+	exportedDevices    *prometheus.Desc
+	ignoredDevices     *prometheus.Desc
+	staleness          *prometheus.Desc
+	breakerState       *prometheus.Desc
+	// deviceValue is nil unless Options.DeviceValue.Enabled.
+	deviceValue *prometheus.Desc
+}
+
+func newCollector(m *monitor) (*collector, error) {
+	opts := m.opts
+	rules := opts.Rules
+	if rules == nil {
+		rules = DefaultRules()
+	}
+	compiled, err := compileRules(opts, rules)
+	if err != nil {
+		return nil, err
+	}
+	rval := &collector{
+		m:                  m,
+		rules:              compiled,
+		now:                now(opts),
+		rejectedDevices:    rejectedDevices(opts),
+		lastUpdateUnixTime: lastUpdateUnixTime(opts),
+		deviceState:        deviceState(opts),
+		eventInfo:          eventInfo(opts),
+		deviceInfo:         deviceInfo(opts),
+		labelCollisions:    labelCollisions(opts),
+		allDevices:         allDevices(opts),
+		exportedDevices:    exportedDevices(opts),
+		ignoredDevices:     ignoredDevices(opts),
+		staleness:          staleness(opts),
+		breakerState:       breakerState(opts),
+	}
+	if opts.DeviceValue.Enabled {
+		rval.deviceValue = deviceValue(opts)
+	}
+	return rval, nil
+}
+
+// metricFor returns how d, a device in s, is exported, if it is.
+func (c *collector) metricFor(s *snapshot, d devstatus.Device) (deviceMetric, bool) {
+	unit := s.units[d.Reference]
+	for i := range c.rules {
+		if c.rules[i].matches(d, unit) {
+			return c.rules[i].metric, true
+		}
+	}
+	return deviceMetric{}, false
+}
+
+// statusUnits returns the unit each device's status names, by reference.
+func statusUnits(devices []devstatus.Device) map[int]string {
+	rval := make(map[int]string, len(devices))
+	for _, d := range devices {
+		rval[d.Reference] = d.ParsedStatus().Unit
+	}
+	return rval
+}
+
+// valueExported reports whether d is exported as device_value.
+func (c *collector) valueExported(d devstatus.Device) bool {
+	dv := c.m.opts.DeviceValue
+	switch {
+	case c.deviceValue == nil,
+		dv.SkipHidden && d.HideFromView,
+		dv.SkipRoots && d.Relationship == devstatus.RootDevice:
+		return false
+	}
+	return true
+}
+
+// labelValues returns the values for deviceLabels.  parentDevice is the
+// name of the device's root device, if it has one.
+func (c *collector) labelValues(s *snapshot, d devstatus.Device) []string {
+	parent := ""
+	if root, ok := s.tree.Root(d.Reference); ok {
+		parent = root.Name
+	}
+	if c.m.opts.RefLabel {
+		return []string{d.Location2, d.Location, d.Name, parent, strconv.Itoa(d.Reference)}
+	}
+	return []string{d.Location2, d.Location, d.Name, parent}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	described := make(map[*prometheus.Desc]bool)
+	for _, r := range c.rules {
+		for _, desc := range []*prometheus.Desc{r.metric.desc, r.metric.legacy} {
+			if desc != nil && !described[desc] {
+				described[desc] = true
+				ch <- desc
+			}
+		}
+	}
+	ch <- c.now
+	ch <- c.rejectedDevices
+	ch <- c.lastUpdateUnixTime
+	ch <- c.deviceState
+	ch <- c.eventInfo
+	ch <- c.deviceInfo
+	ch <- c.labelCollisions
+	ch <- c.allDevices
+	ch <- c.exportedDevices
+	ch <- c.ignoredDevices
+	ch <- c.staleness
+	ch <- c.breakerState
+	if c.deviceValue != nil {
+		ch <- c.deviceValue
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.now, prometheus.GaugeValue, float64(time.Now().Unix()))
+	state := c.m.client.BreakerState()
+	for _, st := range []devstatus.BreakerState{devstatus.BreakerClosed, devstatus.BreakerOpen, devstatus.BreakerHalfOpen} {
+		v := 0.0
+		if st == state {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.breakerState, prometheus.GaugeValue, v, st.String())
+	}
+	s, pushed, stale := c.m.current()
+	if s == nil {
+		return
+	}
+	age := 0.0
+	if stale {
+		age = time.Since(s.taken).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(c.staleness, prometheus.GaugeValue, age)
+	ch <- prometheus.MustNewConstMetric(c.rejectedDevices, prometheus.GaugeValue, float64(s.rejected))
+	ch <- prometheus.MustNewConstMetric(c.labelCollisions, prometheus.GaugeValue, float64(len(s.collisions)))
+
+	// Devices that share a name and location would collide.  As when these
+	// were GaugeVecs, the last one wins, so walk backwards and keep the first
+	// seen.
+	seenValue := make(map[seriesKey]bool)
+	seenDevice := make(map[string]bool)
+	exported := 0
+	for i := len(s.devices) - 1; i >= 0; i-- {
+		d := s.devices[i]
+		if dc, ok := pushed[d.Reference]; ok {
+			d.Value = dc.Value
+			d.LastChange = dc.Received
+		}
+		labels := c.labelValues(s, d)
+		info := labels[:len(labels):len(labels)]
+		if !c.m.opts.RefLabel {
+			info = append(info, strconv.Itoa(d.Reference))
+		}
+		info = append(info, d.DeviceType, d.Type.APIDescription, d.Type.TypeDescription, d.Type.SubTypeDescription,
+			d.Relationship.String(), strconv.FormatBool(d.HideFromView), d.UserAccess)
+		ch <- prometheus.MustNewConstMetric(c.deviceInfo, prometheus.GaugeValue, 1, info...)
+		if c.valueExported(d) {
+			ch <- prometheus.MustNewConstMetric(c.deviceValue, prometheus.GaugeValue, d.Value,
+				d.Location2, d.Location, d.Name, strconv.Itoa(d.Reference), d.DeviceType)
+		}
+		dm, ok := c.metricFor(s, d)
+		if !ok {
+			continue
+		}
+		exported++
+		c.collectDevice(ch, s, dm, d, labels, seenValue, seenDevice)
+	}
+	ch <- prometheus.MustNewConstMetric(c.allDevices, prometheus.GaugeValue, float64(len(s.devices)+s.rejected))
+	ch <- prometheus.MustNewConstMetric(c.exportedDevices, prometheus.GaugeValue, float64(exported))
+	ch <- prometheus.MustNewConstMetric(c.ignoredDevices, prometheus.GaugeValue, float64(len(s.devices)-exported))
+	// Devices still in homeseer win over stale ones with the same labels.
+	for _, sd := range s.stale {
+		c.collectDevice(ch, s, sd.metric, sd.device, sd.labels, seenValue, seenDevice)
+	}
+
+	seenEvent := make(map[int]bool)
+	for _, e := range s.events {
+		if seenEvent[e.ID] {
+			continue
+		}
+		seenEvent[e.ID] = true
+		ch <- prometheus.MustNewConstMetric(c.eventInfo, prometheus.GaugeValue, 1,
+			strconv.Itoa(e.ID), e.Group, e.Name, e.VoiceCommand)
+	}
+}
+
+// collectDevice emits the series for one device, unless series with the
+// same labels were already emitted.
+func (c *collector) collectDevice(ch chan<- prometheus.Metric, s *snapshot, dm deviceMetric, d devstatus.Device,
+	labels []string, seenValue map[seriesKey]bool, seenDevice map[string]bool) {
+	key := strings.Join(labels, "\xff")
+	v := dm.value(s.controls[d.Reference], d, s.units[d.Reference])
+	if vk := (seriesKey{dm.desc, key}); !seenValue[vk] {
+		seenValue[vk] = true
+		ch <- prometheus.MustNewConstMetric(dm.desc, prometheus.GaugeValue, v, labels...)
+	}
+	if vk := (seriesKey{dm.legacy, key}); dm.legacy != nil && !seenValue[vk] {
+		seenValue[vk] = true
+		ch <- prometheus.MustNewConstMetric(dm.legacy, prometheus.GaugeValue, toFahrenheit(v), labels...)
+	}
+	if seenDevice[key] {
+		return
+	}
+	seenDevice[key] = true
+	ch <- prometheus.MustNewConstMetric(c.lastUpdateUnixTime, prometheus.GaugeValue, float64(d.LastChange.Unix()), labels...)
+	if state, ok := s.controls[d.Reference].StateName(d.Value); ok {
+		ch <- prometheus.MustNewConstMetric(c.deviceState, prometheus.GaugeValue, 1, append(labels, state)...)
+	}
+}
+
+// binaryValue maps a two-state device's value to 0 or 1, using its
+// ControlPairs when known and otherwise treating any non-zero value (such as
+// 255) as 1.
+func binaryValue(dc devstatus.DeviceControl, value float64) float64 {
+	if v, ok := dc.Binary(value); ok {
+		return v
+	}
+	if value != 0 {
+		return 1
+	}
+	return 0
+}
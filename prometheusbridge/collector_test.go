@@ -0,0 +1,232 @@
+package prometheusbridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+// deviceTypes cycles through every exported device type, plus one that is
+// not exported.
+var deviceTypes = []string{
+	"Z-Wave Temperature",
+	"Z-Wave Relative Humidity",
+	"Z-Wave Battery",
+	"Z-Wave Luminance",
+	"Z-Wave Ultraviolet",
+	"Z-Wave Sensor Binary",
+	"Z-Wave Switch",
+	"Z-Wave Switch Multilevel",
+	"Z-Wave Central Scene",
+}
+
+// largeReport returns a report of n devices, one root device per five.
+func largeReport(n int) *devstatus.StatusReport {
+	st := &devstatus.StatusReport{}
+	for i := 0; i < n; i++ {
+		d := devstatus.Device{
+			Reference:  i + 1,
+			Name:       fmt.Sprintf("Device %d", i),
+			Location:   fmt.Sprintf("Room %d", i/20),
+			Location2:  fmt.Sprintf("Floor %d", i/500),
+			Value:      float64(i % 100),
+			LastChange: time.Unix(int64(1600000000+i), 0),
+			DeviceType: deviceTypes[i%len(deviceTypes)],
+		}
+		if i%5 != 0 {
+			d.AssociatedDevices = []int{i - i%5 + 1}
+		}
+		st.Devices = append(st.Devices, d)
+	}
+	return st
+}
+
+func newLargeMonitor(tb testing.TB, n int) *monitor {
+	save := devstatusget
+	saveControl := devstatusgetcontrol
+	saveEvents := devstatusgetevents
+	defer func() {
+		devstatusget = save
+		devstatusgetcontrol = saveControl
+		devstatusgetevents = saveEvents
+	}()
+	st := largeReport(n)
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return st, nil
+	}
+	devstatusgetcontrol = func(c *devstatus.Client, ctx context.Context) (*devstatus.ControlReport, error) {
+		return &devstatus.ControlReport{}, nil
+	}
+	devstatusgetevents = func(c *devstatus.Client, ctx context.Context) (*devstatus.EventReport, error) {
+		return &devstatus.EventReport{}, nil
+	}
+	mon, err := internalNew(Options{
+		BaseURL:    "http://127.0.0.1:8080",
+		Registerer: prometheus.NewRegistry(),
+		Location1:  "room",
+		Location2:  "floor",
+	})
+	if err != nil {
+		tb.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		tb.Fatalf("pollOnce(): %v", err)
+	}
+	return mon
+}
+
+func TestCollectLargeReport(t *testing.T) {
+	mon := newLargeMonitor(t, 2000)
+	exported := 0
+	for _, d := range largeReport(2000).Devices {
+		if d.DeviceType != "Z-Wave Central Scene" {
+			exported++
+		}
+	}
+	// Each exported device has a value and a last_update, every device has
+	// device_info, plus current_unix_time, rejected_devices,
+	// label_collisions, staleness_seconds, the three device counts and the
+	// three circuit breaker states.
+	if got, want := testutil.CollectAndCount(mon.collector), 2*exported+2000+10; got != want {
+		t.Errorf("CollectAndCount(): got %d, want %d", got, want)
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	mon := newLargeMonitor(b, 2000)
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(mon.collector); err != nil {
+		b.Fatalf("Register(): %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := reg.Gather(); err != nil {
+			b.Fatalf("Gather(): %v", err)
+		}
+	}
+}
+
+func TestDeviceValue(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Sump Pump", Relationship: devstatus.RootDevice, DeviceType: "Z-Wave Root"},
+				{Reference: 2, Name: "Water Level", Value: 3.5, Relationship: devstatus.Child,
+					AssociatedDevices: []int{1}, DeviceType: "Acme Water Level", Location: "Basement"},
+				{Reference: 3, Name: "Debug", Value: 7, HideFromView: true, DeviceType: "Virtual"},
+				{Reference: 4, Name: "Den", Value: 70, DeviceType: "Z-Wave Temperature"},
+			},
+		}, nil
+	}
+	for _, tc := range []struct {
+		name string
+		opts DeviceValueOptions
+		want string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name: "all",
+			opts: DeviceValueOptions{Enabled: true},
+			want: `
+# HELP hs_device_value The value of a homeseer device, whatever its type
+# TYPE hs_device_value gauge
+hs_device_value{device="Debug",device_type="Virtual",l1="",l2="",ref="3"} 7
+hs_device_value{device="Den",device_type="Z-Wave Temperature",l1="",l2="",ref="4"} 70
+hs_device_value{device="Sump Pump",device_type="Z-Wave Root",l1="",l2="",ref="1"} 0
+hs_device_value{device="Water Level",device_type="Acme Water Level",l1="Basement",l2="",ref="2"} 3.5
+`,
+		},
+		{
+			name: "filtered",
+			opts: DeviceValueOptions{Enabled: true, SkipHidden: true, SkipRoots: true},
+			want: `
+# HELP hs_device_value The value of a homeseer device, whatever its type
+# TYPE hs_device_value gauge
+hs_device_value{device="Den",device_type="Z-Wave Temperature",l1="",l2="",ref="4"} 70
+hs_device_value{device="Water Level",device_type="Acme Water Level",l1="Basement",l2="",ref="2"} 3.5
+`,
+		},
+	} {
+		mon, err := internalNew(Options{
+			Namespace:   "hs",
+			BaseURL:     "http://127.0.0.1:8080",
+			Registerer:  prometheus.NewRegistry(),
+			DeviceValue: tc.opts,
+			Location1:   "l1",
+			Location2:   "l2",
+		})
+		if err != nil {
+			t.Fatalf("%s: New(): %v", tc.name, err)
+		}
+		if err := mon.pollOnce(context.Background()); err != nil {
+			t.Fatalf("%s: pollOnce(): %v", tc.name, err)
+		}
+		if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(tc.want), "hs_device_value"); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestDeviceInfoLabels(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Window Sensor", Location: "Den", Relationship: devstatus.RootDevice, AssociatedDevices: []int{2}},
+				{Reference: 2, Name: "Battery", Location: "Den", Relationship: devstatus.Child, AssociatedDevices: []int{1}},
+				{Reference: 3, Name: "Door Sensor", Location: "Den", Relationship: devstatus.RootDevice, AssociatedDevices: []int{4}},
+				{Reference: 4, Name: "Battery", Location: "Den", Relationship: devstatus.Child, AssociatedDevices: []int{3}},
+			},
+		}, nil
+	}
+	for _, refLabel := range []bool{false, true} {
+		mon, err := internalNew(Options{
+			Namespace:  "hs",
+			BaseURL:    "http://127.0.0.1:8080",
+			Registerer: prometheus.NewRegistry(),
+			RefLabel:   refLabel,
+			Location1:  "l1",
+			Location2:  "l2",
+		})
+		if err != nil {
+			t.Fatalf("New(): %v", err)
+		}
+		if err := mon.pollOnce(context.Background()); err != nil {
+			t.Fatalf("pollOnce(): %v", err)
+		}
+		want := `
+# HELP hs_device_info Always 1, labeled with the details of a homeseer device
+# TYPE hs_device_info gauge
+hs_device_info{api="",device="Battery",device_type="",hidden="false",l1="Den",l2="",parentDevice="Door Sensor",ref="4",relationship="child",subtype="",type="",user_access=""} 1
+hs_device_info{api="",device="Battery",device_type="",hidden="false",l1="Den",l2="",parentDevice="Window Sensor",ref="2",relationship="child",subtype="",type="",user_access=""} 1
+hs_device_info{api="",device="Door Sensor",device_type="",hidden="false",l1="Den",l2="",parentDevice="",ref="3",relationship="root",subtype="",type="",user_access=""} 1
+hs_device_info{api="",device="Window Sensor",device_type="",hidden="false",l1="Den",l2="",parentDevice="",ref="1",relationship="root",subtype="",type="",user_access=""} 1
+`
+		if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want), "hs_device_info"); err != nil {
+			t.Errorf("RefLabel %v: %v", refLabel, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package prometheusbridge
+
+import (
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+// collision is a device that was not exported because a later device in the
+// same poll has the same metric and labels.
+type collision struct {
+	lost devstatus.Device
+	kept devstatus.Device
+}
+
+func labelCollisions(opts Options) *prometheus.Desc {
+	return newDesc(opts, "label_collisions",
+		"Devices in the last poll not exported because another device has the same metric and labels", nil)
+}
+
+// logCollisions logs collisions in s that were not in the previous poll,
+// so a persistent collision is logged once.  m.mu must be held.
+func (m *monitor) logCollisions(s *snapshot) {
+	collided := make(map[int]bool, len(s.collisions))
+	for _, c := range s.collisions {
+		collided[c.lost.Reference] = true
+		if m.collided[c.lost.Reference] {
+			continue
+		}
+		glog.Warningf("device %d (%q in %q, %q) is not exported because device %d has the same labels; "+
+			"rename one or export a ref label", c.lost.Reference, c.lost.Name, c.lost.Location, c.lost.Location2,
+			c.kept.Reference)
+	}
+	m.collided = collided
+}
@@ -0,0 +1,77 @@
+package prometheusbridge
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+func TestLabelCollisions(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Sensor", Location: "Den"},
+				{Reference: 2, Name: "Battery", Location: "Den", Value: 80, AssociatedDevices: []int{1}, DeviceType: "Z-Wave Battery"},
+				{Reference: 3, Name: "Battery", Location: "Den", Value: 40, AssociatedDevices: []int{1}, DeviceType: "Z-Wave Battery"},
+			},
+		}, nil
+	}
+	for _, tc := range []struct {
+		refLabel bool
+		want     string
+	}{
+		{
+			want: `
+# HELP hs_battery_percent Percent of charge remaining in a battery
+# TYPE hs_battery_percent gauge
+hs_battery_percent{device="Battery",l1="Den",l2="",parentDevice="Sensor"} 40
+# HELP hs_label_collisions Devices in the last poll not exported because another device has the same metric and labels
+# TYPE hs_label_collisions gauge
+hs_label_collisions 1
+`,
+		},
+		{
+			refLabel: true,
+			want: `
+# HELP hs_battery_percent Percent of charge remaining in a battery
+# TYPE hs_battery_percent gauge
+hs_battery_percent{device="Battery",l1="Den",l2="",parentDevice="Sensor",ref="2"} 80
+hs_battery_percent{device="Battery",l1="Den",l2="",parentDevice="Sensor",ref="3"} 40
+# HELP hs_label_collisions Devices in the last poll not exported because another device has the same metric and labels
+# TYPE hs_label_collisions gauge
+hs_label_collisions 0
+`,
+		},
+	} {
+		mon, err := internalNew(Options{
+			Namespace:  "hs",
+			BaseURL:    "http://127.0.0.1:8080",
+			Registerer: prometheus.NewRegistry(),
+			RefLabel:   tc.refLabel,
+			Location1:  "l1",
+			Location2:  "l2",
+		})
+		if err != nil {
+			t.Fatalf("New(): %v", err)
+		}
+		if err := mon.pollOnce(context.Background()); err != nil {
+			t.Fatalf("pollOnce(): %v", err)
+		}
+		if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(tc.want),
+			"hs_battery_percent", "hs_label_collisions"); err != nil {
+			t.Errorf("RefLabel %v: %v", tc.refLabel, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package prometheusbridge
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func up(opts Options) (prometheus.Gauge, error) {
+	r := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "up",
+		Help:      "1 if the last attempt to read devices from homeseer succeeded, 0 otherwise",
+	})
+	return r, opts.Registerer.Register(r)
+}
+
+func getstatusDuration(opts Options) (prometheus.Histogram, error) {
+	r := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "getstatus_duration_seconds",
+		Help:      "Time taken to read devices from homeseer, including retries",
+		Buckets:   prometheus.DefBuckets,
+	})
+	return r, opts.Registerer.Register(r)
+}
+
+func responseSize(opts Options) (prometheus.Gauge, error) {
+	r := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "response_size_bytes",
+		Help:      "Size of the last getstatus response from homeseer",
+	})
+	return r, opts.Registerer.Register(r)
+}
+
+func parseErrors(opts Options) (*prometheus.CounterVec, error) {
+	r := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "parse_errors_total",
+			Help:      "Homeseer responses, or devices within them, that could not be decoded",
+		},
+		[]string{"kind"})
+	return r, opts.Registerer.Register(r)
+}
+
+func allDevices(opts Options) *prometheus.Desc {
+	return newDesc(opts, "devices", "Devices in the last homeseer response, whether or not they could be decoded", nil)
+}
+
+func exportedDevices(opts Options) *prometheus.Desc {
+	return newDesc(opts, "exported_devices", "Devices in the last homeseer response exported by a rule", nil)
+}
+
+func ignoredDevices(opts Options) *prometheus.Desc {
+	return newDesc(opts, "ignored_devices", "Devices in the last homeseer response that no rule exports", nil)
+}
+
+func staleness(opts Options) *prometheus.Desc {
+	return newDesc(opts, "staleness_seconds",
+		"Seconds since the device values served were read, when the last poll failed and MaxStaleness allows older values; 0 otherwise", nil)
+}
+
+func breakerState(opts Options) *prometheus.Desc {
+	return newDesc(opts, "circuit_breaker_state",
+		"1 for the state the circuit breaker in front of homeseer is in, 0 for the others", []string{"state"})
+}
@@ -0,0 +1,199 @@
+package prometheusbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+	"github.com/jeffbstewart/homeseer_exporter/hstest"
+)
+
+func TestFailedPollIsServed(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer s.Close()
+	mon, err := internalNew(Options{
+		Namespace:  "hs",
+		BaseURL:    s.URL,
+		Registerer: prometheus.NewRegistry(),
+		Location1:  "room",
+		Location2:  "floor",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	scrape := func() string {
+		rw := httptest.NewRecorder()
+		mon.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("ServeHTTP(): got code %d, want 200", rw.Code)
+		}
+		return rw.Body.String()
+	}
+
+	body := scrape()
+	for _, want := range []string{
+		"hs_up 1",
+		"hs_devices 6",
+		"hs_exported_devices 5",
+		"hs_ignored_devices 1",
+		"hs_getstatus_duration_seconds_count 1",
+		`hs_parse_errors_total{kind="device"} 0`,
+		`hs_temperature_degreesf{`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("healthy scrape: missing %s", want)
+		}
+	}
+	if strings.Contains(body, "hs_response_size_bytes 0") {
+		t.Errorf("healthy scrape: response size is 0")
+	}
+
+	s.SetFixture("getstatus", []byte("{not json"))
+	body = scrape()
+	for _, want := range []string{
+		"hs_up 0",
+		"hs_getstatus_duration_seconds_count 2",
+		`hs_parse_errors_total{kind="response"} 1`,
+		`hs_scrape_failures_total{reason="malformed",request="getstatus"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("failed scrape: missing %s", want)
+		}
+	}
+	// Values from before the failure must not be passed off as current.
+	if strings.Contains(body, "hs_temperature_degreesf{") {
+		t.Errorf("failed scrape: served device values from an earlier poll")
+	}
+}
+
+func TestMaxStaleness(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer s.Close()
+	mon, err := internalNew(Options{
+		Namespace:    "hs",
+		BaseURL:      s.URL,
+		Registerer:   prometheus.NewRegistry(),
+		MaxStaleness: time.Hour,
+		Location1:    "room",
+		Location2:    "floor",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	if got := gaugeValue(t, mon, "hs_staleness_seconds"); got != 0 {
+		t.Errorf("staleness_seconds after a good poll: got %v, want 0", got)
+	}
+
+	s.DisableJSON()
+	if err := mon.pollOnce(context.Background()); err == nil {
+		t.Fatalf("pollOnce(): got nil error with JSON disabled")
+	}
+	if got := testutil.ToFloat64(mon.up); got != 0 {
+		t.Errorf("up: got %v, want 0", got)
+	}
+	if got := gaugeValue(t, mon, "hs_temperature_degreesf"); got != 72.5 {
+		t.Errorf("temperature within MaxStaleness: got %v, want 72.5", got)
+	}
+	if got := gaugeValue(t, mon, "hs_staleness_seconds"); got <= 0 {
+		t.Errorf("staleness_seconds while stale: got %v, want > 0", got)
+	}
+
+	// Once the last good poll is too old, nothing is served.
+	mon.mu.Lock()
+	mon.latest.taken = mon.latest.taken.Add(-2 * time.Hour)
+	mon.mu.Unlock()
+	if got := testutil.CollectAndCount(mon.collector, "hs_temperature_degreesf", "hs_staleness_seconds"); got != 0 {
+		t.Errorf("series beyond MaxStaleness: got %d, want 0", got)
+	}
+}
+
+func TestCircuitBreakerState(t *testing.T) {
+	s, err := hstest.NewServer(hstest.Options{})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	mon, err := internalNew(Options{
+		Namespace:        "hs",
+		BaseURL:          s.URL,
+		Registerer:       prometheus.NewRegistry(),
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Hour,
+		Location1:        "room",
+		Location2:        "floor",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	want := func(state string) string {
+		var b strings.Builder
+		b.WriteString("# HELP hs_circuit_breaker_state 1 for the state the circuit breaker in front of homeseer is in, 0 for the others\n")
+		b.WriteString("# TYPE hs_circuit_breaker_state gauge\n")
+		for _, st := range []string{"closed", "half_open", "open"} {
+			v := 0
+			if st == state {
+				v = 1
+			}
+			fmt.Fprintf(&b, "hs_circuit_breaker_state{state=%q} %d\n", st, v)
+		}
+		return b.String()
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want("closed")), "hs_circuit_breaker_state"); err != nil {
+		t.Errorf("healthy: %v", err)
+	}
+
+	s.Close()
+	if err := mon.pollOnce(context.Background()); err == nil {
+		t.Fatalf("pollOnce(): got nil error with homeseer down")
+	}
+	err = mon.pollOnce(context.Background())
+	if !errors.Is(err, devstatus.ErrCircuitOpen) {
+		t.Errorf("pollOnce() with the breaker open: got %v, want ErrCircuitOpen", err)
+	}
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want("open")), "hs_circuit_breaker_state"); err != nil {
+		t.Errorf("homeseer down: %v", err)
+	}
+	if got := testutil.ToFloat64(mon.scrapeFailures.WithLabelValues("getstatus", "circuit_open")); got != 1 {
+		t.Errorf(`scrape_failures_total{request="getstatus",reason="circuit_open"}: got %v, want 1`, got)
+	}
+}
+
+// gaugeValue returns the value of the first series of the named gauge
+// collected from mon.
+func gaugeValue(t *testing.T, mon *monitor, name string) float64 {
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(mon.collector); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather(): %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name && len(mf.GetMetric()) > 0 {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("%s: not collected", name)
+	return 0
+}
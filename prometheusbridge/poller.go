@@ -0,0 +1,91 @@
+package prometheusbridge
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Monitor is a running exporter for one homeseer, as returned by Start.
+type Monitor struct {
+	m *monitor
+}
+
+// Start creates and starts a monitor for the given target.  Unlike New, it
+// returns the Monitor so its background work can be stopped.
+func Start(opts Options) (*Monitor, error) {
+	m, err := internalNew(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Monitor{m: m}, nil
+}
+
+// Stop shuts down the background poller and ASCII watcher, if any, and
+// waits for them to exit.  Stop may be called more than once.
+func (mon *Monitor) Stop() {
+	mon.m.stop()
+}
+
+// Handler serves the monitor's metrics, polling homeseer as needed.  It is
+// already mounted at /metrics on http.DefaultServeMux unless
+// Options.Registerer was set.
+func (mon *Monitor) Handler() http.Handler {
+	return mon.m
+}
+
+func pollAge(opts Options, m *monitor) (prometheus.GaugeFunc, error) {
+	r := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "last_poll_age_seconds",
+			Help:      "Seconds since device values were last read from homeseer; +Inf before the first successful read",
+		},
+		func() float64 {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.latest == nil {
+				return math.Inf(1)
+			}
+			return time.Since(m.latest.taken).Seconds()
+		})
+	return r, opts.Registerer.Register(r)
+}
+
+// startPoller polls homeseer immediately and then every PollInterval until
+// the monitor stops.
+func (m *monitor) startPoller() {
+	m.ticker = time.NewTicker(m.opts.PollInterval)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer m.ticker.Stop()
+		for {
+			if err := m.pollOnce(m.ctx); err != nil {
+				glog.Errorf("pollOnce(): %v", err)
+			}
+			// Let anyone waiting know a poll finished, without blocking on them.
+			select {
+			case m.pulse <- nil:
+			default:
+			}
+			select {
+			case <-m.close:
+				return
+			case <-m.ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *monitor) stop() {
+	m.stopOnce.Do(func() {
+		m.cancel()
+		close(m.close)
+	})
+	m.wg.Wait()
+}
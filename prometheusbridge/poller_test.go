@@ -0,0 +1,112 @@
+package prometheusbridge
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+func TestBackgroundPoller(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	var calls int32
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		atomic.AddInt32(&calls, 1)
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Name: "Thermostat", Value: 72.0, DeviceType: "Z-Wave Temperature"},
+			},
+		}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace:    t.Name(),
+		BaseURL:      "http://127.0.0.1:8080",
+		PollInterval: time.Hour,
+		Location1:    "l1",
+		Location2:    "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	defer mon.stop()
+	select {
+	case <-mon.pulse:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no background poll within 10s")
+	}
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		mon.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+		if rw.Code != http.StatusOK {
+			t.Errorf("ServeHTTP(): got code %d, want 200", rw.Code)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("devstatus.Get calls: got %d, want 1", got)
+	}
+	if age := testutil.ToFloat64(mon.pollAge); age < 0 || age > 60 {
+		t.Errorf("pollAge: got %v, want a small positive age", age)
+	}
+}
+
+func TestStopEndsPolling(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	var calls int32
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		atomic.AddInt32(&calls, 1)
+		return &devstatus.StatusReport{}, nil
+	}
+	mon, err := Start(Options{
+		Namespace:    t.Name(),
+		BaseURL:      "http://127.0.0.1:8080",
+		PollInterval: time.Millisecond,
+		Location1:    "l1",
+		Location2:    "l2",
+	})
+	if err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	<-mon.m.pulse
+	mon.Stop()
+	mon.Stop()
+	stopped := atomic.LoadInt32(&calls)
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != stopped {
+		t.Errorf("devstatus.Get calls after Stop: got %d, want %d", got, stopped)
+	}
+}
+
+func TestPollAgeBeforeFirstPoll(t *testing.T) {
+	noHandle(t)
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if age := testutil.ToFloat64(mon.pollAge); !math.IsInf(age, 1) {
+		t.Errorf("pollAge: got %v, want +Inf", age)
+	}
+}
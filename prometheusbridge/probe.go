@@ -0,0 +1,135 @@
+package prometheusbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+// ProbeConfig lists the homeseers that a Prober can probe.
+type ProbeConfig struct {
+	Targets []ProbeTarget `json:"targets"`
+}
+
+// ProbeTarget is one homeseer, named so its credentials stay out of probe URLs.
+type ProbeTarget struct {
+	// Name is the value of the target parameter that selects this homeseer.
+	Name string `json:"name"`
+	// BaseURL is as for Options.BaseURL.
+	BaseURL            string `json:"hs4_url"`
+	Username           string `json:"user"`
+	Password           string `json:"pass"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// LoadProbeConfig reads a JSON ProbeConfig from path.
+func LoadProbeConfig(path string) (*ProbeConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rval := &ProbeConfig{}
+	if err := json.Unmarshal(b, rval); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, t := range rval.Targets {
+		if t.Name == "" {
+			return nil, fmt.Errorf("%s: target %d has no name", path, i)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("%s: target %q is listed twice", path, t.Name)
+		}
+		seen[t.Name] = true
+		if t.BaseURL == "" {
+			return nil, fmt.Errorf("%s: target %q has no hs4_url", path, t.Name)
+		}
+	}
+	return rval, nil
+}
+
+// Prober serves /probe?target=<name>, polling the named homeseer and
+// returning its device metrics along with probe_success and
+// probe_duration_seconds, in the style of the blackbox exporter.
+type Prober struct {
+	monitors map[string]*monitor
+}
+
+// NewProber creates a Prober for the targets in cfg.  opts supplies
+// everything but the connection details, which come from each target.
+// Every target gets its own registry, so nothing is registered globally.
+func NewProber(cfg *ProbeConfig, opts Options) (*Prober, error) {
+	rval := &Prober{monitors: make(map[string]*monitor)}
+	for _, t := range cfg.Targets {
+		to := opts
+		to.BaseURL = t.BaseURL
+		to.Username = t.Username
+		to.Password = t.Password
+		to.TLS = devstatus.TLSOptions{
+			CAFile:             t.CAFile,
+			CertFile:           t.CertFile,
+			KeyFile:            t.KeyFile,
+			InsecureSkipVerify: t.InsecureSkipVerify,
+		}
+		// Probes poll on demand; background work would outlive the Prober.
+		to.PollInterval = 0
+		to.ASCIIAddress = ""
+		reg := prometheus.NewRegistry()
+		to.Registerer = reg
+		to.Gatherer = reg
+		m, err := internalNew(to)
+		if err != nil {
+			return nil, fmt.Errorf("target %q: %v", t.Name, err)
+		}
+		rval.monitors[t.Name] = m
+	}
+	return rval, nil
+}
+
+func (p *Prober) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("target")
+	if name == "" {
+		http.Error(rw, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	m, ok := p.monitors[name]
+	if !ok {
+		http.Error(rw, fmt.Sprintf("unknown target %q", name), http.StatusBadRequest)
+		return
+	}
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "1 if homeseer's devices were read, 0 otherwise",
+	})
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Seconds the probe took to read homeseer's devices",
+	})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(success, duration)
+
+	start := time.Now()
+	err := m.refresh(req.Context())
+	duration.Set(time.Since(start).Seconds())
+	// Like the blackbox exporter, a failed probe is still a successful
+	// scrape, so Prometheus records probe_success.  The monitor leaves out
+	// device values it could not read.
+	if err != nil {
+		glog.Errorf("probe %q: %v", name, err)
+	} else {
+		success.Set(1)
+	}
+	g := prometheus.Gatherers{reg, m.opts.Gatherer}
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(rw, req)
+}
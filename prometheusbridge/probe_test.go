@@ -0,0 +1,104 @@
+package prometheusbridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbstewart/homeseer_exporter/hstest"
+)
+
+func writeProbeConfig(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "probe.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	return path
+}
+
+func TestProbe(t *testing.T) {
+	barn, err := hstest.NewServer(hstest.Options{Username: "barn", Password: "hay"})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer barn.Close()
+	cabin, err := hstest.NewServer(hstest.Options{Username: "cabin", Password: "logs"})
+	if err != nil {
+		t.Fatalf("hstest.NewServer(): %v", err)
+	}
+	defer cabin.Close()
+	path := writeProbeConfig(t, fmt.Sprintf(`{"targets": [
+		{"name": "barn", "hs4_url": %q, "user": "barn", "pass": "hay"},
+		{"name": "cabin", "hs4_url": %q, "user": "cabin", "pass": "logs"}
+	]}`, barn.URL, cabin.URL))
+	cfg, err := LoadProbeConfig(path)
+	if err != nil {
+		t.Fatalf("LoadProbeConfig(): %v", err)
+	}
+	p, err := NewProber(cfg, Options{Location1: "room", Location2: "floor"})
+	if err != nil {
+		t.Fatalf("NewProber(): %v", err)
+	}
+
+	cabin.DisableJSON()
+	for _, tc := range []struct {
+		target  string
+		want    []string
+		notWant []string
+	}{
+		{
+			target: "barn",
+			want: []string{
+				"probe_success 1",
+				"probe_duration_seconds ",
+				`temperature_degreesf{device="Temperature",floor="Ground Floor",parentDevice="Multisensor",room="Living Room"} 72.5`,
+			},
+		},
+		{
+			target:  "cabin",
+			want:    []string{"probe_success 0", "probe_duration_seconds ", "up 0"},
+			notWant: []string{"temperature_degreesf"},
+		},
+	} {
+		rw := httptest.NewRecorder()
+		p.ServeHTTP(rw, httptest.NewRequest("GET", "/probe?target="+tc.target, nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("probe %s: got code %d, want 200", tc.target, rw.Code)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(rw.Body.String(), want) {
+				t.Errorf("probe %s: missing %s", tc.target, want)
+			}
+		}
+		for _, notWant := range tc.notWant {
+			if strings.Contains(rw.Body.String(), notWant) {
+				t.Errorf("probe %s: unexpected %s", tc.target, notWant)
+			}
+		}
+	}
+
+	for _, query := range []string{"", "?target=attic"} {
+		rw := httptest.NewRecorder()
+		p.ServeHTTP(rw, httptest.NewRequest("GET", "/probe"+query, nil))
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("probe %q: got code %d, want 400", query, rw.Code)
+		}
+	}
+}
+
+func TestLoadProbeConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		`{"targets": [`,
+		`{"targets": [{"hs4_url": "http://barn"}]}`,
+		`{"targets": [{"name": "barn"}]}`,
+		`{"targets": [{"name": "barn", "hs4_url": "http://barn"}, {"name": "barn", "hs4_url": "http://barn2"}]}`,
+	} {
+		if _, err := LoadProbeConfig(writeProbeConfig(t, config)); err == nil {
+			t.Errorf("LoadProbeConfig(%s): got nil error", config)
+		}
+	}
+}
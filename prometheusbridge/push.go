@@ -0,0 +1,73 @@
+package prometheusbridge
+
+import (
+	"context"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+var newWatcher = devstatus.NewWatcher
+
+func valueChanges(opts Options) (*prometheus.CounterVec, error) {
+	r := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "value_changes_total",
+			Help:      "Device value changes pushed by homeseer's ASCII interface, including ones that revert between scrapes",
+		},
+		deviceLabels(opts))
+	return r, opts.Registerer.Register(r)
+}
+
+// startWatcher streams device changes from the ASCII interface until the monitor stops.
+func (m *monitor) startWatcher() error {
+	w, err := newWatcher(devstatus.WatchOptions{
+		Address:  m.opts.ASCIIAddress,
+		Username: m.opts.Username,
+		Password: m.opts.Password,
+	})
+	if err != nil {
+		return err
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := w.Run(m.ctx, m.applyChange); err != nil && err != context.Canceled {
+			glog.Errorf("ASCII watcher: %v", err)
+		}
+	}()
+	return nil
+}
+
+// applyChange records a pushed device change, to be exported until the next
+// poll.  Changes to devices the last poll did not export are ignored.
+func (m *monitor) applyChange(dc devstatus.DeviceChange) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.latest
+	if s == nil {
+		return
+	}
+	d, ok := s.tree.Device(dc.Reference)
+	if !ok {
+		return
+	}
+	if _, ok := m.collector.metricFor(s, d); !ok && !m.collector.valueExported(d) {
+		return
+	}
+	if m.pushed == nil {
+		m.pushed = make(map[int]devstatus.DeviceChange)
+	}
+	m.pushed[dc.Reference] = dc
+	labels := m.collector.labelValues(s, d)
+	if m.changed == nil {
+		m.changed = make(map[string][]string)
+	}
+	m.changed[strings.Join(labels, "\xff")] = labels
+	m.valueChanges.WithLabelValues(labels...).Inc()
+}
@@ -0,0 +1,107 @@
+package prometheusbridge
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+// fakeASCII stands in for homeseer's ASCII interface.  It accepts one
+// login and then writes each line sent on the returned channel.
+func fakeASCII(t *testing.T) (string, chan<- string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	lines := make(chan string)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		_ = l.Close()
+	})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "ok\r\n")
+		for {
+			select {
+			case line := <-lines:
+				fmt.Fprintf(conn, "%s\r\n", line)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return l.Addr().String(), lines
+}
+
+func TestPushedChangesUpdateGauges(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 10, Name: "Garage Door", Value: 0, DeviceType: "Z-Wave Sensor Binary"},
+			},
+		}, nil
+	}
+	addr, lines := fakeASCII(t)
+	mon, err := internalNew(Options{
+		Namespace:    t.Name(),
+		BaseURL:      "http://127.0.0.1:8080",
+		Username:     "user",
+		Password:     "secret",
+		ASCIIAddress: addr,
+		Location1:    "l1",
+		Location2:    "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	defer mon.stop()
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	lines <- "DC,99,1,0"
+	lines <- "DC,10,255,0"
+	lines <- "DC,10,0,255"
+	changes := mon.valueChanges.WithLabelValues("", "", "Garage Door", "")
+	deadline := time.Now().Add(10 * time.Second)
+	for testutil.ToFloat64(changes) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("valueChanges: got %v, want 2", testutil.ToFloat64(changes))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	want := `
+# HELP TestPushedChangesUpdateGauges_sensor_binary A sensor that can be either on or off
+# TYPE TestPushedChangesUpdateGauges_sensor_binary gauge
+TestPushedChangesUpdateGauges_sensor_binary{device="Garage Door",l1="",l2="",parentDevice=""} 0
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestPushedChangesUpdateGauges_sensor_binary"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+	if got := testutil.CollectAndCount(mon.valueChanges); got != 1 {
+		t.Errorf("valueChanges series: got %d, want 1", got)
+	}
+}
@@ -0,0 +1,328 @@
+package prometheusbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+// Rule exports the devices it matches as one metric.  Each device is
+// exported by the first rule it matches, if any.
+type Rule struct {
+	Match RuleMatch `json:"match"`
+	// Metric is the metric name, before Namespace, Subsystem and Unit are applied.
+	Metric string `json:"metric"`
+	Help   string `json:"help"`
+	// Unit, if set, is appended to Metric, as in "temperature_degreesf".
+	Unit string `json:"unit"`
+	// Transform is "" to export the device value as is, "binary" to map
+	// it to 0 or 1 using the device's ControlPairs, or "temperature" to
+	// convert it to the unit in Options.Temperature.  Temperature rules must
+	// leave Unit empty; it, and Help if empty, are set to match.
+	Transform string `json:"transform"`
+	// TemperatureUnit is "C" or "F", the unit the devices a temperature
+	// rule matches report in.  Empty reads it from each device's status,
+	// falling back to Fahrenheit.
+	TemperatureUnit string `json:"temperature_unit"`
+	// Scale multiplies the value after Transform.  Zero means 1.
+	Scale float64 `json:"scale"`
+	// Offset is added to the value after Scale.
+	Offset float64 `json:"offset"`
+	// Labels are added to every series of the metric.
+	Labels map[string]string `json:"labels"`
+}
+
+// RuleMatch selects devices.  Every field that is set must match.
+type RuleMatch struct {
+	// DeviceType matches device_type_string exactly.
+	DeviceType string `json:"device_type_string"`
+	// Name is a regular expression matched against the device name.
+	Name      string `json:"name"`
+	Location  string `json:"location"`
+	Location2 string `json:"location2"`
+	Reference int    `json:"ref"`
+	// StatusUnit matches the unit in the device's status, as
+	// devstatus.ParseStatusString names it, such as "W" or "kW".
+	StatusUnit string `json:"status_unit"`
+	// API, Type and SubType match the device_type fields of the same names.
+	API     *int `json:"device_api"`
+	Type    *int `json:"device_type"`
+	SubType *int `json:"device_subtype"`
+}
+
+// RulesFile is the format read by LoadRules.
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+	// Defaults appends DefaultRules after Rules, so they only apply to
+	// devices Rules does not match.
+	Defaults bool `json:"defaults"`
+}
+
+// LoadRules reads a JSON RulesFile from path.
+func LoadRules(path string) ([]Rule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &RulesFile{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rules := f.Rules
+	if f.Defaults {
+		rules = append(rules, DefaultRules()...)
+	}
+	if _, err := compileRules(Options{}, rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// DefaultRules returns the rules used when Options.Rules is nil.
+func DefaultRules() []Rule {
+	electricMeter := func(name string, metric string, unit string, help string) Rule {
+		return Rule{
+			Match:  RuleMatch{DeviceType: "Z-Wave Electric Meter", Name: "^" + regexp.QuoteMeta(name) + "$"},
+			Metric: metric,
+			Unit:   unit,
+			Help:   help,
+		}
+	}
+	meterUnit := func(statusUnit string, metric string, unit string, scale float64, help string) Rule {
+		return Rule{
+			Match:  RuleMatch{DeviceType: "Z-Wave Electric Meter", StatusUnit: statusUnit},
+			Metric: metric,
+			Unit:   unit,
+			Scale:  scale,
+			Help:   help,
+		}
+	}
+	return []Rule{
+		{Match: RuleMatch{DeviceType: "Z-Wave Temperature"}, Metric: "temperature", Transform: "temperature"},
+		{Match: RuleMatch{DeviceType: "Z-Wave Relative Humidity"}, Metric: "relative_humidity", Unit: "percent",
+			Help: "Relative Humidity, 0 to 100%"},
+
+		{Match: RuleMatch{DeviceType: "Z-Wave Battery"}, Metric: "battery", Unit: "percent",
+			Help: "Percent of charge remaining in a battery"},
+
+		{Match: RuleMatch{DeviceType: "Z-Wave Luminance"}, Metric: "luminance", Unit: "lux",
+			Help: "A measure of light intensity"},
+		{Match: RuleMatch{DeviceType: "Z-Wave Ultraviolet"}, Metric: "ultraviolet", Unit: "index",
+			Help: "A measure of ultraviolet light exposure"},
+
+		// Electric meters report each quantity as a child device.  Tell them
+		// apart by the unit in their status, or failing that by their name.
+		meterUnit("W", "power", "watts", 1, "Instantaneous power consumption"),
+		meterUnit("kW", "power", "watts", 1000, "Instantaneous power consumption"),
+		meterUnit("kWh", "cumulative_power", "kwhours", 1, "Total power consumption over time"),
+		meterUnit("Wh", "cumulative_power", "kwhours", 0.001, "Total power consumption over time"),
+		meterUnit("V", "potential", "volts", 1, "A measure of electrical potential"),
+		meterUnit("A", "current", "amperes", 1, "Instantaneous electrical current"),
+		electricMeter("Watts", "power", "watts", "Instantaneous power consumption"),
+		electricMeter("kW Hours", "cumulative_power", "kwhours", "Total power consumption over time"),
+		electricMeter("Volts", "potential", "volts", "A measure of electrical potential"),
+		electricMeter("Amperes", "current", "amperes", "Instantaneous electrical current"),
+		{Match: RuleMatch{DeviceType: "Z-Wave Watts"}, Metric: "power", Unit: "watts",
+			Help: "Instantaneous power consumption"},
+		{Match: RuleMatch{DeviceType: "Z-Wave kW Hours"}, Metric: "cumulative_power", Unit: "kwhours",
+			Help: "Total power consumption over time"},
+		{Match: RuleMatch{DeviceType: "Z-Wave Volts"}, Metric: "potential", Unit: "volts",
+			Help: "A measure of electrical potential"},
+		{Match: RuleMatch{DeviceType: "Z-Wave Amperes"}, Metric: "current", Unit: "amperes",
+			Help: "Instantaneous electrical current"},
+
+		{Match: RuleMatch{DeviceType: "Z-Wave Sensor Binary"}, Metric: "sensor_binary", Transform: "binary",
+			Help: "A sensor that can be either on or off"},
+
+		{Match: RuleMatch{DeviceType: "Z-Wave Switch"}, Metric: "switch_binary", Transform: "binary",
+			Help: "A switch that is either on or off"},
+		{Match: RuleMatch{DeviceType: "Z-Wave Switch Multilevel"}, Metric: "switch_multilevel",
+			Help: "A dimmable switch"},
+
+		// TODO: Nest special handling
+	}
+}
+
+// builtinMetrics are the metrics the exporter exports itself, before
+// Namespace and Subsystem are applied.  Rules may not export them.
+var builtinMetrics = []string{
+	"current_unix_time", "device_info", "device_state", "device_value", "event_info",
+	"last_update_unix_time", "label_collisions", "value_changes_total",
+	"devices", "exported_devices", "ignored_devices", "rejected_devices", "stale_devices_deleted_total",
+	"up", "last_poll_age_seconds", "staleness_seconds", "circuit_breaker_state",
+	"getstatus_duration_seconds", "getstatus_duration_seconds_bucket", "getstatus_duration_seconds_sum",
+	"getstatus_duration_seconds_count", "response_size_bytes", "parse_errors_total", "scrape_failures_total",
+}
+
+// compiledRule is a Rule ready to match devices.
+type compiledRule struct {
+	Rule
+	name   *regexp.Regexp
+	metric deviceMetric
+}
+
+// compileRules validates rules and builds their descs.  Rules that export
+// the same metric with the same labels share a desc, as a registry requires.
+// Rules that export the same metric must agree on its help and label names.
+func compileRules(opts Options, rules []Rule) ([]compiledRule, error) {
+	celsius, err := opts.Temperature.celsius()
+	if err != nil {
+		return nil, err
+	}
+	builtin := make(map[string]bool)
+	for _, name := range builtinMetrics {
+		builtin[name] = true
+	}
+	// first holds the first rule to export each metric, and its help and
+	// label names.
+	type firstRule struct {
+		index  int
+		help   string
+		labels string
+	}
+	first := make(map[string]firstRule)
+	descs := make(map[string]*prometheus.Desc)
+	desc := func(i int, name string, r Rule) (*prometheus.Desc, error) {
+		if builtin[name] {
+			return nil, fmt.Errorf("rule %d: metric %q is exported by the exporter itself", i, name)
+		}
+		labels := labelNamesKey(r.Labels)
+		if f, ok := first[name]; !ok {
+			first[name] = firstRule{index: i, help: r.Help, labels: labels}
+		} else if f.help != r.Help {
+			return nil, fmt.Errorf("rule %d: help for %s differs from rule %d's", i, name, f.index)
+		} else if f.labels != labels {
+			return nil, fmt.Errorf("rule %d: label names for %s differ from rule %d's", i, name, f.index)
+		}
+		key := name + "\xff" + labelsKey(r.Labels)
+		if descs[key] == nil {
+			descs[key] = prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, name),
+				r.Help, deviceLabels(opts), r.Labels)
+		}
+		return descs[key], nil
+	}
+	reserved := make(map[string]bool)
+	for _, l := range deviceLabels(opts) {
+		reserved[l] = true
+	}
+	rval := make([]compiledRule, 0, len(rules))
+	for i, r := range rules {
+		if r.Metric == "" {
+			return nil, fmt.Errorf("rule %d: metric is required", i)
+		}
+		for l := range r.Labels {
+			switch {
+			case !model.LabelName(l).IsValid():
+				return nil, fmt.Errorf("rule %d: invalid label name %q", i, l)
+			case reserved[l]:
+				return nil, fmt.Errorf("rule %d: label %q is already a device label", i, l)
+			}
+		}
+		c := compiledRule{Rule: r}
+		if r.Match.Name != "" {
+			re, err := regexp.Compile(r.Match.Name)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: name: %v", i, err)
+			}
+			c.name = re
+		}
+		unit := r.Unit
+		switch r.Transform {
+		case "":
+		case "binary":
+			c.metric.binary = true
+		case "temperature":
+			if unit != "" {
+				return nil, fmt.Errorf("rule %d: unit is set by the temperature transform", i)
+			}
+			c.metric.temperature = true
+			c.metric.celsius = celsius
+			help := "A temperature reading in degrees Fahrenheit"
+			unit = "degreesf"
+			if celsius {
+				unit, help = "celsius", "A temperature reading in degrees Celsius"
+			}
+			if r.Help == "" {
+				r.Help = help
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown transform %q", i, r.Transform)
+		}
+		switch c.metric.reports = strings.ToUpper(r.TemperatureUnit); {
+		case c.metric.reports != "" && !c.metric.temperature:
+			return nil, fmt.Errorf("rule %d: temperature_unit needs the temperature transform", i)
+		case c.metric.reports != "" && c.metric.reports != "C" && c.metric.reports != "F":
+			return nil, fmt.Errorf("rule %d: unknown temperature_unit %q, want C or F", i, r.TemperatureUnit)
+		}
+		c.metric.scale = r.Scale
+		if c.metric.scale == 0 {
+			c.metric.scale = 1
+		}
+		c.metric.offset = r.Offset
+		name := r.Metric
+		if unit != "" {
+			name += "_" + unit
+		}
+		if fq := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, name); !model.IsValidMetricName(model.LabelValue(fq)) {
+			return nil, fmt.Errorf("rule %d: invalid metric name %q", i, fq)
+		}
+		if c.metric.desc, err = desc(i, name, r); err != nil {
+			return nil, err
+		}
+		if c.metric.celsius && opts.Temperature.LegacyDegreesF {
+			legacy := r
+			legacy.Help = "A temperature reading in degrees Fahrenheit"
+			if c.metric.legacy, err = desc(i, r.Metric+"_degreesf", legacy); err != nil {
+				return nil, err
+			}
+		}
+		rval = append(rval, c)
+	}
+	return rval, nil
+}
+
+// labelsKey returns a string that is equal for equal label sets.
+func labelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xff")
+}
+
+// labelNamesKey returns a string that is equal for label sets with equal
+// names.
+func labelNamesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\xff")
+}
+
+// matches reports whether d, whose status names unit, matches the rule.
+func (c *compiledRule) matches(d devstatus.Device, unit string) bool {
+	m := c.Match
+	switch {
+	case m.DeviceType != "" && m.DeviceType != d.DeviceType,
+		m.Reference != 0 && m.Reference != d.Reference,
+		m.Location != "" && m.Location != d.Location,
+		m.Location2 != "" && m.Location2 != d.Location2,
+		m.API != nil && *m.API != d.Type.API,
+		m.Type != nil && *m.Type != d.Type.Type,
+		m.SubType != nil && *m.SubType != d.Type.SubType,
+		m.StatusUnit != "" && m.StatusUnit != unit:
+		return false
+	}
+	return c.name == nil || c.name.MatchString(d.Name)
+}
@@ -0,0 +1,246 @@
+package prometheusbridge
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+func writeRules(t *testing.T, rules string) string {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := ioutil.WriteFile(path, []byte(rules), 0600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	return path
+}
+
+func TestRules(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Tank Level", Value: 50, DeviceType: "Z-Wave Switch Multilevel"},
+				{Reference: 2, Name: "Lamp", Value: 99, DeviceType: "Z-Wave Switch Multilevel"},
+				{Reference: 3, Name: "Fridge", Value: 4, DeviceType: "Acme Probe", Type: devstatus.DeviceType{API: 4, Type: 16}},
+				{Reference: 4, Name: "Freezer", Value: -18, DeviceType: "Acme Probe", Type: devstatus.DeviceType{API: 4, Type: 17}},
+				{Reference: 5, Name: "Meter", Value: 1200, DeviceType: "Z-Wave Electric Meter"},
+				{Reference: 6, Name: "Watts", Value: 300, DeviceType: "Z-Wave Electric Meter"},
+			},
+		}, nil
+	}
+	rules, err := LoadRules(writeRules(t, `{
+		"rules": [
+			{"match": {"name": "^Tank"}, "metric": "tank_level", "unit": "ratio", "scale": 0.01,
+			 "help": "How full a tank is"},
+			{"match": {"device_type_string": "Acme Probe", "device_api": 4, "device_type": 16},
+			 "metric": "temperature", "unit": "celsius", "labels": {"appliance": "fridge"},
+			 "help": "A temperature reading in degrees Celsius"}
+		],
+		"defaults": true
+	}`))
+	if err != nil {
+		t.Fatalf("LoadRules(): %v", err)
+	}
+	mon, err := internalNew(Options{
+		Namespace:  "hs",
+		BaseURL:    "http://127.0.0.1:8080",
+		Registerer: prometheus.NewRegistry(),
+		Rules:      rules,
+		Location1:  "l1",
+		Location2:  "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP hs_power_watts Instantaneous power consumption
+# TYPE hs_power_watts gauge
+hs_power_watts{device="Watts",l1="",l2="",parentDevice=""} 300
+# HELP hs_switch_multilevel A dimmable switch
+# TYPE hs_switch_multilevel gauge
+hs_switch_multilevel{device="Lamp",l1="",l2="",parentDevice=""} 99
+# HELP hs_tank_level_ratio How full a tank is
+# TYPE hs_tank_level_ratio gauge
+hs_tank_level_ratio{device="Tank Level",l1="",l2="",parentDevice=""} 0.5
+# HELP hs_temperature_celsius A temperature reading in degrees Celsius
+# TYPE hs_temperature_celsius gauge
+hs_temperature_celsius{appliance="fridge",device="Fridge",l1="",l2="",parentDevice=""} 4
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"hs_power_watts", "hs_switch_multilevel", "hs_tank_level_ratio", "hs_temperature_celsius"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	for _, rules := range []string{
+		`{"rules": [`,
+		`{"rules": [{"match": {"name": "Tank"}}]}`,
+		`{"rules": [{"match": {"name": "("}, "metric": "tank"}]}`,
+		`{"rules": [{"metric": "tank", "transform": "sqrt"}]}`,
+		`{"rules": [{"metric": "bad-name"}]}`,
+		`{"rules": [{"metric": "tank", "unit": "litres!"}]}`,
+		`{"rules": [{"metric": "tank", "labels": {"fluid-type": "oil"}}]}`,
+		`{"rules": [{"metric": "tank", "labels": {"device": "oil tank"}}]}`,
+		`{"rules": [{"metric": "tank", "labels": {"parentDevice": "boiler"}}]}`,
+	} {
+		if _, err := LoadRules(writeRules(t, rules)); err == nil {
+			t.Errorf("LoadRules(%s): got nil error", rules)
+		}
+	}
+
+	// Rules sharing a metric must agree on it, and must not reuse the
+	// exporter's own metrics.
+	for _, tc := range []struct {
+		rules string
+		want  string
+	}{
+		{`{"rules": [{"metric": "tank", "help": "Tank level"}, {"metric": "tank", "help": "Oil tank level"}]}`,
+			": rule 1: help for tank differs from rule 0's"},
+		{`{"rules": [{"metric": "tank", "labels": {"fluid": "oil"}}, {"metric": "tank"}]}`,
+			": rule 1: label names for tank differ from rule 0's"},
+		{`{"rules": [{"metric": "tank", "labels": {"fluid": "oil"}}, {"metric": "tank", "labels": {"tank": "oil"}}]}`,
+			": rule 1: label names for tank differ from rule 0's"},
+		{`{"rules": [{"metric": "tank"}, {"metric": "device_info"}]}`, ": rule 1: "},
+		{`{"rules": [{"metric": "tank"}, {"metric": "devices"}]}`, ": rule 1: "},
+		{`{"rules": [{"metric": "tank"}, {"metric": "getstatus_duration", "unit": "seconds"}]}`, ": rule 1: "},
+		// The clash is found in the defaults appended after rule 1.
+		{`{"rules": [{"metric": "tank"}, {"metric": "switch_multilevel", "help": "A dimmer"}], "defaults": true}`,
+			"help for switch_multilevel differs from rule 1's"},
+	} {
+		if _, err := LoadRules(writeRules(t, tc.rules)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("LoadRules(%s): got %v, want an error containing %q", tc.rules, err, tc.want)
+		}
+	}
+	if _, err := LoadRules(writeRules(t, `{"rules": [
+		{"match": {"name": "^Oil"}, "metric": "tank", "labels": {"fluid": "oil"}},
+		{"match": {"name": "^Water"}, "metric": "tank", "labels": {"fluid": "water"}}
+	]}`)); err != nil {
+		t.Errorf("LoadRules() with label values that differ: %v", err)
+	}
+
+	// Location labels are only known once the rules meet Options.
+	rules := []Rule{{Metric: "tank"}, {Metric: "tank", Labels: map[string]string{"room": "garage"}}}
+	_, err := internalNew(Options{
+		BaseURL:    "http://127.0.0.1:8080",
+		Registerer: prometheus.NewRegistry(),
+		Rules:      rules,
+		Location1:  "room",
+		Location2:  "floor",
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "rule 1: ") {
+		t.Errorf("New() with a rule labeling room: got %v, want a rule 1 error", err)
+	}
+}
+
+func TestBuiltinMetrics(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{Devices: []devstatus.DeviceControl{
+		{Reference: 1, ControlPairs: []devstatus.ControlPair{{Label: "Off", ControlValue: 0}}},
+	}})
+	stubEvents(t, &devstatus.EventReport{Events: []devstatus.Event{{ID: 1, Name: "Porch Light On"}}})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Lamp", Value: 0, DeviceType: "Z-Wave Switch Multilevel"},
+			},
+		}, nil
+	}
+	reg := prometheus.NewRegistry()
+	mon, err := internalNew(Options{
+		Namespace:   "hs",
+		BaseURL:     "http://127.0.0.1:8080",
+		Registerer:  reg,
+		DeviceValue: DeviceValueOptions{Enabled: true},
+		Location1:   "l1",
+		Location2:   "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	mon.applyChange(devstatus.DeviceChange{Reference: 1, Value: 99})
+	known := map[string]bool{"switch_multilevel": true}
+	for _, name := range builtinMetrics {
+		known[name] = true
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather(): %v", err)
+	}
+	for _, mf := range mfs {
+		if name := strings.TrimPrefix(mf.GetName(), "hs_"); !known[name] {
+			t.Errorf("%s is missing from builtinMetrics", name)
+		}
+	}
+}
+
+func TestElectricMeterStatusUnits(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Heat Pump Power", Value: 1.2, Status: "1.2 kW", DeviceType: "Z-Wave Electric Meter"},
+				{Reference: 2, Name: "Heat Pump Energy", Value: 1234.5, Status: "1234.5 kW Hours", DeviceType: "Z-Wave Electric Meter"},
+				{Reference: 3, Name: "Dryer Power", Value: 300, Status: "300 W", DeviceType: "Z-Wave Electric Meter"},
+				// No unit in its status, so its name decides.
+				{Reference: 4, Name: "Watts", Value: 40, DeviceType: "Z-Wave Electric Meter"},
+			},
+		}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace:  "hs",
+		BaseURL:    "http://127.0.0.1:8080",
+		Registerer: prometheus.NewRegistry(),
+		Location1:  "l1",
+		Location2:  "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP hs_cumulative_power_kwhours Total power consumption over time
+# TYPE hs_cumulative_power_kwhours gauge
+hs_cumulative_power_kwhours{device="Heat Pump Energy",l1="",l2="",parentDevice=""} 1234.5
+# HELP hs_power_watts Instantaneous power consumption
+# TYPE hs_power_watts gauge
+hs_power_watts{device="Dryer Power",l1="",l2="",parentDevice=""} 300
+hs_power_watts{device="Heat Pump Power",l1="",l2="",parentDevice=""} 1200
+hs_power_watts{device="Watts",l1="",l2="",parentDevice=""} 40
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"hs_power_watts", "hs_cumulative_power_kwhours"); err != nil {
+		t.Errorf("collector: %v", err)
+	}
+}
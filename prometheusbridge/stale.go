@@ -0,0 +1,84 @@
+package prometheusbridge
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+// seenDevice is an exported device as of the last poll that included it.
+type seenDevice struct {
+	device devstatus.Device
+	metric deviceMetric
+	labels []string
+	seen   time.Time
+}
+
+func staleDeleted(opts Options) (prometheus.Counter, error) {
+	r := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: opts.Namespace,
+		Subsystem: opts.Subsystem,
+		Name:      "stale_devices_deleted_total",
+		Help:      "Devices no longer exported because they disappeared from homeseer or were renamed",
+	})
+	return r, opts.Registerer.Register(r)
+}
+
+// expire records the devices s exports.  Devices exported by earlier polls
+// but not by s are carried in s.stale until StaleGracePeriod has passed,
+// and then counted as deleted and their value_changes_total series
+// deleted.  Devices whose series collide with a later device's are
+// recorded in s.collisions.  m.mu must be held.
+func (m *monitor) expire(s *snapshot) {
+	seen := make(map[seriesKey]seenDevice, len(m.seen))
+	// live holds the labels of every device still exported.
+	live := make(map[string]bool)
+	for _, d := range s.devices {
+		dm, ok := m.collector.metricFor(s, d)
+		if !ok {
+			if len(m.changed) > 0 && m.collector.valueExported(d) {
+				live[strings.Join(m.collector.labelValues(s, d), "\xff")] = true
+			}
+			continue
+		}
+		labels := m.collector.labelValues(s, d)
+		k := seriesKey{dm.desc, strings.Join(labels, "\xff")}
+		if prev, ok := seen[k]; ok {
+			// The collector keeps the last device, so prev is lost.
+			s.collisions = append(s.collisions, collision{lost: prev.device, kept: d})
+		}
+		seen[k] = seenDevice{
+			device: d,
+			metric: dm,
+			labels: labels,
+			seen:   s.taken,
+		}
+	}
+	for k, sd := range m.seen {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		if s.taken.Sub(sd.seen) < m.opts.StaleGracePeriod {
+			seen[k] = sd
+			s.stale = append(s.stale, sd)
+			if _, ok := s.units[sd.device.Reference]; !ok {
+				s.units[sd.device.Reference] = sd.device.ParsedStatus().Unit
+			}
+			continue
+		}
+		m.staleDeleted.Inc()
+	}
+	m.seen = seen
+	for k := range seen {
+		live[k.labels] = true
+	}
+	for key, labels := range m.changed {
+		if !live[key] {
+			m.valueChanges.DeleteLabelValues(labels...)
+			delete(m.changed, key)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package prometheusbridge
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+func TestRenamedDeviceExpires(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	name := "Attic"
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: name, Value: 90, DeviceType: "Z-Wave Temperature"},
+			},
+		}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace:        t.Name(),
+		BaseURL:          "http://127.0.0.1:8080",
+		StaleGracePeriod: time.Hour,
+		Location1:        "l1",
+		Location2:        "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	name = "Attic Sensor"
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want := `
+# HELP TestRenamedDeviceExpires_temperature_degreesf A temperature reading in degrees Fahrenheit
+# TYPE TestRenamedDeviceExpires_temperature_degreesf gauge
+TestRenamedDeviceExpires_temperature_degreesf{device="Attic",l1="",l2="",parentDevice=""} 90
+TestRenamedDeviceExpires_temperature_degreesf{device="Attic Sensor",l1="",l2="",parentDevice=""} 90
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestRenamedDeviceExpires_temperature_degreesf"); err != nil {
+		t.Errorf("within grace period: %v", err)
+	}
+	if got := testutil.ToFloat64(mon.staleDeleted); got != 0 {
+		t.Errorf("staleDeleted within grace period: got %v, want 0", got)
+	}
+
+	// Age the old name past the grace period.
+	mon.mu.Lock()
+	for k, sd := range mon.seen {
+		sd.seen = sd.seen.Add(-2 * time.Hour)
+		mon.seen[k] = sd
+	}
+	mon.mu.Unlock()
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	want = `
+# HELP TestRenamedDeviceExpires_temperature_degreesf A temperature reading in degrees Fahrenheit
+# TYPE TestRenamedDeviceExpires_temperature_degreesf gauge
+TestRenamedDeviceExpires_temperature_degreesf{device="Attic Sensor",l1="",l2="",parentDevice=""} 90
+`
+	if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(want),
+		"TestRenamedDeviceExpires_temperature_degreesf"); err != nil {
+		t.Errorf("after grace period: %v", err)
+	}
+	if got := testutil.ToFloat64(mon.staleDeleted); got != 1 {
+		t.Errorf("staleDeleted: got %v, want 1", got)
+	}
+}
+
+func TestRenamedDeviceDropsValueChanges(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	name := "Garage Door"
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 10, Name: name, Value: 0, DeviceType: "Z-Wave Sensor Binary"},
+			},
+		}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	mon.applyChange(devstatus.DeviceChange{Reference: 10, Value: 255, Received: time.Now()})
+	want := `
+# HELP TestRenamedDeviceDropsValueChanges_value_changes_total Device value changes pushed by homeseer's ASCII interface, including ones that revert between scrapes
+# TYPE TestRenamedDeviceDropsValueChanges_value_changes_total counter
+TestRenamedDeviceDropsValueChanges_value_changes_total{device="Garage Door",l1="",l2="",parentDevice=""} 1
+`
+	if err := testutil.CollectAndCompare(mon.valueChanges, strings.NewReader(want)); err != nil {
+		t.Errorf("after a pushed change: %v", err)
+	}
+
+	name = "Garage Side Door"
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	if got := testutil.CollectAndCount(mon.valueChanges); got != 0 {
+		t.Errorf("value_changes_total series after rename: got %d, want 0", got)
+	}
+}
+
+func TestRemovedDeviceDeletedWithoutGracePeriod(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devices := []devstatus.Device{
+		{Reference: 1, Name: "Attic", Value: 90, DeviceType: "Z-Wave Temperature"},
+		{Reference: 2, Name: "Doorbell", DeviceType: "Z-Wave Central Scene"},
+	}
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{Devices: devices}, nil
+	}
+	mon, err := internalNew(Options{
+		Namespace: t.Name(),
+		BaseURL:   "http://127.0.0.1:8080",
+		Location1: "l1",
+		Location2: "l2",
+	})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	devices = nil
+	if err := mon.pollOnce(context.Background()); err != nil {
+		t.Fatalf("pollOnce(): %v", err)
+	}
+	// Only exported devices count.
+	if got := testutil.ToFloat64(mon.staleDeleted); got != 1 {
+		t.Errorf("staleDeleted: got %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(mon.collector, "TestRemovedDeviceDeletedWithoutGracePeriod_temperature_degreesf"); got != 0 {
+		t.Errorf("temperature series: got %d, want 0", got)
+	}
+}
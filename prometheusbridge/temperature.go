@@ -0,0 +1,48 @@
+package prometheusbridge
+
+import "fmt"
+
+// TemperatureOptions control how rules with the "temperature" transform
+// export temperatures.
+type TemperatureOptions struct {
+	// Unit is "celsius" or "fahrenheit", the unit every temperature is
+	// converted to.  The metric name ends in _celsius or _degreesf to match.
+	// Empty means fahrenheit, as before units were detected.
+	Unit string
+	// LegacyDegreesF, when Unit is celsius, also exports each temperature
+	// in Fahrenheit under the _degreesf name, so dashboards can be moved
+	// over before the old metric goes away.
+	LegacyDegreesF bool
+}
+
+// celsius reports whether temperatures are exported in Celsius.
+func (t TemperatureOptions) celsius() (bool, error) {
+	switch t.Unit {
+	case "", "fahrenheit":
+		return false, nil
+	case "celsius":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown temperature unit %q, want celsius or fahrenheit", t.Unit)
+}
+
+// temperatureUnit returns "C" or "F" for the unit a device reports in:
+// reports if a rule set it, otherwise unit, the unit in its status, if it
+// is one, otherwise Fahrenheit, which is homeseer's default.
+func temperatureUnit(reports string, unit string) string {
+	if reports != "" {
+		return reports
+	}
+	if unit == "C" || unit == "F" {
+		return unit
+	}
+	return "F"
+}
+
+func toCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+func toFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
@@ -0,0 +1,145 @@
+package prometheusbridge
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jeffbstewart/homeseer_exporter/devstatus"
+)
+
+func TestTemperatureUnit(t *testing.T) {
+	for _, tc := range []struct {
+		reports string
+		status  string
+		want    string
+	}{
+		{status: "21.5 °C", want: "C"},
+		{status: "21.5°C", want: "C"},
+		{status: "21.5 &deg;C", want: "C"},
+		{status: " 21.5 c ", want: "C"},
+		{status: "72.5 F", want: "F"},
+		{status: "72.5 °F", want: "F"},
+		{status: "", want: "F"},
+		{status: "Off", want: "F"},
+		{reports: "C", status: "72.5 F", want: "C"},
+	} {
+		if got := temperatureUnit(tc.reports, devstatus.ParseStatusString(tc.status).Unit); got != tc.want {
+			t.Errorf("temperatureUnit(%q, %q): got %q, want %q", tc.reports, tc.status, got, tc.want)
+		}
+	}
+}
+
+func TestTemperature(t *testing.T) {
+	noHandle(t)
+	stubControl(t, &devstatus.ControlReport{})
+	stubEvents(t, &devstatus.EventReport{})
+	save := devstatusget
+	defer func() {
+		devstatusget = save
+	}()
+	devstatusget = func(c *devstatus.Client, ctx context.Context) (*devstatus.StatusReport, error) {
+		return &devstatus.StatusReport{
+			Devices: []devstatus.Device{
+				{Reference: 1, Name: "Kitchen", Value: 20, Status: "20 °C", DeviceType: "Z-Wave Temperature"},
+				{Reference: 2, Name: "Den", Value: 50, Status: "50 F", DeviceType: "Z-Wave Temperature"},
+				// Reports Celsius without saying so.
+				{Reference: 3, Name: "Cellar", Value: 10, Status: "10", DeviceType: "Z-Wave Temperature"},
+			},
+		}, nil
+	}
+	rules := append([]Rule{
+		{Match: RuleMatch{Reference: 3}, Metric: "temperature", Transform: "temperature", TemperatureUnit: "C"},
+	}, DefaultRules()...)
+	for _, tc := range []struct {
+		name    string
+		opts    TemperatureOptions
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "fahrenheit",
+			metrics: []string{"hs_temperature_degreesf", "hs_temperature_celsius"},
+			want: `
+# HELP hs_temperature_degreesf A temperature reading in degrees Fahrenheit
+# TYPE hs_temperature_degreesf gauge
+hs_temperature_degreesf{device="Cellar",l1="",l2="",parentDevice=""} 50
+hs_temperature_degreesf{device="Den",l1="",l2="",parentDevice=""} 50
+hs_temperature_degreesf{device="Kitchen",l1="",l2="",parentDevice=""} 68
+`,
+		},
+		{
+			name:    "celsius",
+			opts:    TemperatureOptions{Unit: "celsius"},
+			metrics: []string{"hs_temperature_degreesf", "hs_temperature_celsius"},
+			want: `
+# HELP hs_temperature_celsius A temperature reading in degrees Celsius
+# TYPE hs_temperature_celsius gauge
+hs_temperature_celsius{device="Cellar",l1="",l2="",parentDevice=""} 10
+hs_temperature_celsius{device="Den",l1="",l2="",parentDevice=""} 10
+hs_temperature_celsius{device="Kitchen",l1="",l2="",parentDevice=""} 20
+`,
+		},
+		{
+			name:    "legacy",
+			opts:    TemperatureOptions{Unit: "celsius", LegacyDegreesF: true},
+			metrics: []string{"hs_temperature_degreesf"},
+			want: `
+# HELP hs_temperature_degreesf A temperature reading in degrees Fahrenheit
+# TYPE hs_temperature_degreesf gauge
+hs_temperature_degreesf{device="Cellar",l1="",l2="",parentDevice=""} 50
+hs_temperature_degreesf{device="Den",l1="",l2="",parentDevice=""} 50
+hs_temperature_degreesf{device="Kitchen",l1="",l2="",parentDevice=""} 68
+`,
+		},
+	} {
+		mon, err := internalNew(Options{
+			Namespace:   "hs",
+			BaseURL:     "http://127.0.0.1:8080",
+			Registerer:  prometheus.NewRegistry(),
+			Rules:       rules,
+			Temperature: tc.opts,
+			Location1:   "l1",
+			Location2:   "l2",
+		})
+		if err != nil {
+			t.Fatalf("%s: New(): %v", tc.name, err)
+		}
+		if err := mon.pollOnce(context.Background()); err != nil {
+			t.Fatalf("%s: pollOnce(): %v", tc.name, err)
+		}
+		if err := testutil.CollectAndCompare(mon.collector, strings.NewReader(tc.want), tc.metrics...); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestLegacyDegreesFHelp(t *testing.T) {
+	rules, err := compileRules(Options{Temperature: TemperatureOptions{Unit: "celsius", LegacyDegreesF: true}},
+		[]Rule{{Metric: "fridge", Transform: "temperature", Help: "Fridge temperature in degrees Celsius"}})
+	if err != nil {
+		t.Fatalf("compileRules(): %v", err)
+	}
+	if got, want := rules[0].metric.legacy.String(), `help: "A temperature reading in degrees Fahrenheit"`; !strings.Contains(got, want) {
+		t.Errorf("legacy desc: got %s, want %s", got, want)
+	}
+}
+
+func TestTemperatureRuleErrors(t *testing.T) {
+	for _, tc := range []struct {
+		rule Rule
+		opts Options
+	}{
+		{rule: Rule{Metric: "temperature", Transform: "temperature", Unit: "kelvin"}},
+		{rule: Rule{Metric: "temperature", Unit: "celsius", TemperatureUnit: "C"}},
+		{rule: Rule{Metric: "temperature", Transform: "temperature", TemperatureUnit: "K"}},
+		{rule: Rule{Metric: "temperature", Transform: "temperature"}, opts: Options{Temperature: TemperatureOptions{Unit: "kelvin"}}},
+	} {
+		if _, err := compileRules(tc.opts, []Rule{tc.rule}); err == nil {
+			t.Errorf("compileRules(%+v, %+v): got nil error", tc.opts.Temperature, tc.rule)
+		}
+	}
+}